@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"datatunerx-server/config"
 	"datatunerx-server/internalp/handler"
 	"datatunerx-server/pkg/k8s"
+	"datatunerx-server/pkg/metrics"
 	"datatunerx-server/pkg/ray"
 	"datatunerx-server/pkg/s3"
 
 	"github.com/DataTunerX/utility-server/logging"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -22,6 +29,11 @@ func main() {
 	if err != nil {
 		logging.ZLogger.Errorf("Error initializing ray client: %v", err)
 	}
+	// Initialize the rayservice informer cache
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	rayServiceCache := ray.NewRayServiceCache(rayClients, config.GetWatchNamespace(), config.GetRayServiceResyncPeriod())
+	rayServiceCache.Start(stopCh)
 	// Initialize S3 client
 	s3Client, err := s3.NewS3Client(config.GetS3ServiceEndpoint(), config.GetS3ServiceAccessKey(), config.GetS3ServiceSecretKey(), config.GetS3ServiceUseSSL())
 	if err != nil {
@@ -29,25 +41,101 @@ func main() {
 	}
 	// Initialize Gin Engine
 	router := gin.Default()
+	router.Use(metrics.Middleware())
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// health check routes
+	healthHandler := handler.NewHealthHandler(rayServiceCache)
+	router.GET("/healthz", healthHandler.LivenessHandler)
+	router.GET("/readyz", healthHandler.ReadinessHandler)
 
 	apiGroup := router.Group("/apis/util.datatunerx.io/v1beta1")
 	namespaceGroup := apiGroup.Group("/namespaces/:namespace")
 
 	// plugin webhook routes
+	resourceHandler := handler.NewResourceHandler(kubeClients, rayClients, rayServiceCache)
 	resourceUpdate := namespaceGroup.Group("/:resourceKind/:resourceName")
 	{
-		resourceUpdate.POST("/:group/:version/:kind/:objName", handler.NewResourceHandler(kubeClients, rayClients).UpdateResourceHandler)
+		resourceUpdate.POST("/:group/:version/:kind/:objName", resourceHandler.UpdateResourceHandler)
 	}
 	// inference service routes
 	inferenceService := namespaceGroup.Group("/services")
 	{
-		inferenceService.GET("", handler.NewResourceHandler(kubeClients, rayClients).ListRayServicesHandler)
-		inferenceService.POST("", handler.NewResourceHandler(kubeClients, rayClients).CreateRayServiceHandler)
+		inferenceService.GET("", resourceHandler.ListRayServicesHandler)
+		inferenceService.POST("", resourceHandler.CreateRayServiceHandler)
+		inferenceService.GET("/:serviceName", resourceHandler.GetRayServiceHandler)
+		inferenceService.PATCH("/:serviceName", resourceHandler.PatchRayServiceHandler)
+		inferenceService.DELETE("/:serviceName", resourceHandler.DeleteRayServiceHandler)
 	}
 	// inference proxy routes
+	inferenceHandler := handler.NewInferenceHandler(kubeClients, rayClients, rayServiceCache, s3Client)
 	inferenceProxy := namespaceGroup.Group("/services/:serviceName/inference")
 	{
-		inferenceProxy.POST("/chat", handler.NewInferenceHandler(kubeClients, rayClients).InferenceChatHandler)
+		inferenceProxy.POST("/chat", inferenceHandler.InferenceChatHandler)
+		inferenceProxy.POST("/completions", inferenceHandler.InferenceCompletionsHandler)
+		inferenceProxy.POST("/embeddings", inferenceHandler.InferenceEmbeddingsHandler)
+		inferenceProxy.GET("/ws", inferenceHandler.ChatSessionWebSocketHandler)
+		inferenceProxy.GET("/sessions/:sessionId", inferenceHandler.GetChatSessionHandler)
+		inferenceProxy.POST("/sessions/:sessionId/reset", inferenceHandler.ResetChatSessionHandler)
+	}
+	namespaceGroup.POST("/inference/compare", inferenceHandler.InferenceCompareHandler)
+	namespaceGroup.GET("/inference/usage", inferenceHandler.UsageReportHandler)
+	// inference feedback routes
+	feedbackHandler := handler.NewFeedbackHandler(inferenceHandler, s3Client)
+	namespaceGroup.POST("/inference/feedback", feedbackHandler.SubmitFeedbackHandler)
+	namespaceGroup.GET("/inference/feedback/export", feedbackHandler.ExportFeedbackHandler)
+	// batch inference routes
+	batchInference := namespaceGroup.Group("/inference/batch")
+	{
+		batchHandler := handler.NewBatchInferenceHandler(inferenceHandler, s3Client)
+		batchInference.POST("", batchHandler.SubmitBatchJobHandler)
+		batchInference.GET("", batchHandler.ListBatchJobsHandler)
+		batchInference.GET("/:jobId", batchHandler.GetBatchJobHandler)
+		batchInference.POST("/:jobId/cancel", batchHandler.CancelBatchJobHandler)
+	}
+	// OpenAI-compatible routes, the model field names the rayservice
+	openAI := namespaceGroup.Group("/v1")
+	{
+		openAI.POST("/chat/completions", inferenceHandler.OpenAIChatCompletionsHandler)
+		openAI.GET("/models", inferenceHandler.OpenAIListModelsHandler)
+	}
+
+	// namespace guardrails
+	guardrails := namespaceGroup.Group("/guardrails")
+	{
+		guardrails.GET("", inferenceHandler.GetGuardrailsHandler)
+		guardrails.PUT("", inferenceHandler.PutGuardrailsHandler)
+		guardrails.DELETE("", inferenceHandler.DeleteGuardrailsHandler)
+	}
+	// weighted inference routes
+	inferenceRoutes := namespaceGroup.Group("/routes")
+	{
+		inferenceRoutes.GET("", inferenceHandler.ListRoutesHandler)
+		inferenceRoutes.GET("/:routeName", inferenceHandler.GetRouteHandler)
+		inferenceRoutes.PUT("/:routeName", inferenceHandler.PutRouteHandler)
+		inferenceRoutes.DELETE("/:routeName", inferenceHandler.DeleteRouteHandler)
+		inferenceRoutes.POST("/:routeName/inference/chat", inferenceHandler.RouteChatHandler)
+	}
+
+	// prompt template routes
+	promptTemplateHandler := handler.NewPromptTemplateHandler(kubeClients)
+	promptTemplates := namespaceGroup.Group("/prompt-templates")
+	{
+		promptTemplates.GET("", promptTemplateHandler.ListPromptTemplatesHandler)
+		promptTemplates.POST("", promptTemplateHandler.CreatePromptTemplateHandler)
+		promptTemplates.GET("/:templateName", promptTemplateHandler.GetPromptTemplateHandler)
+		promptTemplates.PUT("/:templateName", promptTemplateHandler.UpdatePromptTemplateHandler)
+		promptTemplates.DELETE("/:templateName", promptTemplateHandler.DeletePromptTemplateHandler)
+		promptTemplates.POST("/:templateName/preview", promptTemplateHandler.PreviewPromptTemplateHandler)
+	}
+
+	// admin routes
+	admin := apiGroup.Group("/admin")
+	{
+		admin.GET("/ratelimits", inferenceHandler.RateLimitsHandler)
+		admin.GET("/breakers", inferenceHandler.BreakersHandler)
+		admin.POST("/breakers/:name/reset", inferenceHandler.ResetBreakerHandler)
+		admin.GET("/response-cache", inferenceHandler.ResponseCacheHandler)
 	}
 
 	// finetune metrics routes
@@ -67,5 +155,24 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
-	router.Run(":" + port)
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.ZLogger.Errorf("HTTP server failed: %v", err)
+			stop()
+		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	// 停止接收新请求，等待处理中的请求结束后写出缓冲的记录
+	logging.ZLogger.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.GetShutdownTimeout())
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logging.ZLogger.Errorf("Failed to shut down HTTP server: %v", err)
+	}
+	inferenceHandler.Close(shutdownCtx)
 }
@@ -1,6 +1,11 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 var config *viper.Viper
 
@@ -11,11 +16,145 @@ func init() {
 	config.SetDefault("level", "debug")
 	config.BindEnv("inferenceServiceLabel", "INFERENCE_SERVICE_LABEL")
 	config.SetDefault("inferenceServiceLabel", "serviceType=inferenceService")
+	config.BindEnv("watchNamespace", "WATCH_NAMESPACE")
+	config.SetDefault("watchNamespace", "")
+	config.BindEnv("rayServiceResyncPeriod", "RAYSERVICE_RESYNC_PERIOD")
+	config.SetDefault("rayServiceResyncPeriod", "10m")
 	config.BindEnv("s3ServiceEndpoint", "S3_SERVICE_ENDPOINT")
 	config.BindEnv("s3ServiceAccessKey", "S3_SERVICE_ACCESSKEY")
 	config.BindEnv("s3ServiceSecretKey", "S3_SERVICE_SECRETKEY")
 	config.BindEnv("s3ServiceUseSSL", "S3_SERVICE_USESSL")
 	config.SetDefault("s3ServiceUseSSL", false)
+	config.BindEnv("s3Bucket", "S3_BUCKET")
+	config.SetDefault("s3Bucket", "datatunerx")
+	config.BindEnv("inferenceCompareTimeout", "INFERENCE_COMPARE_TIMEOUT")
+	config.SetDefault("inferenceCompareTimeout", "120s")
+	config.BindEnv("batchInferenceConcurrency", "BATCH_INFERENCE_CONCURRENCY")
+	config.SetDefault("batchInferenceConcurrency", 4)
+	config.BindEnv("batchInferenceMaxConcurrency", "BATCH_INFERENCE_MAX_CONCURRENCY")
+	config.SetDefault("batchInferenceMaxConcurrency", 32)
+	config.BindEnv("batchInferenceMaxRows", "BATCH_INFERENCE_MAX_ROWS")
+	config.SetDefault("batchInferenceMaxRows", 10000)
+	config.BindEnv("batchInferenceRowTimeout", "BATCH_INFERENCE_ROW_TIMEOUT")
+	config.SetDefault("batchInferenceRowTimeout", "300s")
+	config.BindEnv("batchInferenceRetention", "BATCH_INFERENCE_RETENTION")
+	config.SetDefault("batchInferenceRetention", "24h")
+	config.BindEnv("inferenceDialTimeout", "INFERENCE_DIAL_TIMEOUT")
+	config.SetDefault("inferenceDialTimeout", "5s")
+	config.BindEnv("inferenceResponseHeaderTimeout", "INFERENCE_RESPONSE_HEADER_TIMEOUT")
+	config.SetDefault("inferenceResponseHeaderTimeout", "300s")
+	config.BindEnv("inferenceRequestTimeout", "INFERENCE_REQUEST_TIMEOUT")
+	config.SetDefault("inferenceRequestTimeout", "600s")
+	config.BindEnv("inferenceMaxIdleConns", "INFERENCE_MAX_IDLE_CONNS")
+	config.SetDefault("inferenceMaxIdleConns", 256)
+	config.BindEnv("inferenceMaxIdleConnsPerHost", "INFERENCE_MAX_IDLE_CONNS_PER_HOST")
+	config.SetDefault("inferenceMaxIdleConnsPerHost", 32)
+	config.BindEnv("inferenceIdleConnTimeout", "INFERENCE_IDLE_CONN_TIMEOUT")
+	config.SetDefault("inferenceIdleConnTimeout", "90s")
+	config.BindEnv("inferenceMaxRetries", "INFERENCE_MAX_RETRIES")
+	config.SetDefault("inferenceMaxRetries", 2)
+	config.BindEnv("inferenceRetryBackoff", "INFERENCE_RETRY_BACKOFF")
+	config.SetDefault("inferenceRetryBackoff", "200ms")
+	config.BindEnv("inferenceBreakerFailureThreshold", "INFERENCE_BREAKER_FAILURE_THRESHOLD")
+	config.SetDefault("inferenceBreakerFailureThreshold", 5)
+	config.BindEnv("inferenceBreakerOpenDuration", "INFERENCE_BREAKER_OPEN_DURATION")
+	config.SetDefault("inferenceBreakerOpenDuration", "30s")
+	config.BindEnv("promptTemplateConfigMap", "PROMPT_TEMPLATE_CONFIGMAP")
+	config.SetDefault("promptTemplateConfigMap", "datatunerx-prompt-templates")
+	config.BindEnv("inferenceResponseCacheSize", "INFERENCE_RESPONSE_CACHE_SIZE")
+	config.SetDefault("inferenceResponseCacheSize", 0)
+	config.BindEnv("inferenceResponseCacheTTL", "INFERENCE_RESPONSE_CACHE_TTL")
+	config.SetDefault("inferenceResponseCacheTTL", "1h")
+	config.BindEnv("inferenceRouteConfigMap", "INFERENCE_ROUTE_CONFIGMAP")
+	config.SetDefault("inferenceRouteConfigMap", "datatunerx-inference-routes")
+	config.BindEnv("inferenceSessionMaxTurns", "INFERENCE_SESSION_MAX_TURNS")
+	config.SetDefault("inferenceSessionMaxTurns", 20)
+	config.BindEnv("inferenceSessionMaxTokens", "INFERENCE_SESSION_MAX_TOKENS")
+	config.SetDefault("inferenceSessionMaxTokens", 4096)
+	config.BindEnv("inferenceSessionIdleTimeout", "INFERENCE_SESSION_IDLE_TIMEOUT")
+	config.SetDefault("inferenceSessionIdleTimeout", "1h")
+	config.BindEnv("inferenceWebSocketAllowedOrigins", "INFERENCE_WEBSOCKET_ALLOWED_ORIGINS")
+	config.SetDefault("inferenceWebSocketAllowedOrigins", "")
+	config.BindEnv("guardrailConfigMap", "GUARDRAIL_CONFIGMAP")
+	config.SetDefault("guardrailConfigMap", "datatunerx-guardrails")
+	config.BindEnv("guardrailCacheTTL", "GUARDRAIL_CACHE_TTL")
+	config.SetDefault("guardrailCacheTTL", "10s")
+	config.BindEnv("inferenceFeedbackPrefix", "INFERENCE_FEEDBACK_PREFIX")
+	config.SetDefault("inferenceFeedbackPrefix", "feedback")
+	config.BindEnv("inferenceFeedbackWindow", "INFERENCE_FEEDBACK_WINDOW")
+	config.SetDefault("inferenceFeedbackWindow", "24h")
+	config.BindEnv("inferenceFeedbackMaxPending", "INFERENCE_FEEDBACK_MAX_PENDING")
+	config.SetDefault("inferenceFeedbackMaxPending", 10000)
+	config.BindEnv("rayServiceHeadCPURequest", "RAYSERVICE_HEAD_CPU_REQUEST")
+	config.SetDefault("rayServiceHeadCPURequest", "1000m")
+	config.BindEnv("rayServiceHeadCPULimit", "RAYSERVICE_HEAD_CPU_LIMIT")
+	config.SetDefault("rayServiceHeadCPULimit", "2000m")
+	config.BindEnv("rayServiceHeadMemoryRequest", "RAYSERVICE_HEAD_MEMORY_REQUEST")
+	config.SetDefault("rayServiceHeadMemoryRequest", "4Gi")
+	config.BindEnv("rayServiceHeadMemoryLimit", "RAYSERVICE_HEAD_MEMORY_LIMIT")
+	config.SetDefault("rayServiceHeadMemoryLimit", "8Gi")
+	config.BindEnv("rayServiceWorkerCPURequest", "RAYSERVICE_WORKER_CPU_REQUEST")
+	config.SetDefault("rayServiceWorkerCPURequest", "1000m")
+	config.BindEnv("rayServiceWorkerCPULimit", "RAYSERVICE_WORKER_CPU_LIMIT")
+	config.SetDefault("rayServiceWorkerCPULimit", "8000m")
+	config.BindEnv("rayServiceWorkerMemoryRequest", "RAYSERVICE_WORKER_MEMORY_REQUEST")
+	config.SetDefault("rayServiceWorkerMemoryRequest", "48Gi")
+	config.BindEnv("rayServiceWorkerMemoryLimit", "RAYSERVICE_WORKER_MEMORY_LIMIT")
+	config.SetDefault("rayServiceWorkerMemoryLimit", "48Gi")
+	config.BindEnv("rayServiceWorkerReplicas", "RAYSERVICE_WORKER_REPLICAS")
+	config.SetDefault("rayServiceWorkerReplicas", 1)
+	config.BindEnv("rayServiceGPUsPerWorker", "RAYSERVICE_GPUS_PER_WORKER")
+	config.SetDefault("rayServiceGPUsPerWorker", 1)
+	config.BindEnv("rayServiceServeReplicas", "RAYSERVICE_SERVE_REPLICAS")
+	config.SetDefault("rayServiceServeReplicas", 1)
+	config.BindEnv("rayServiceGPUsPerReplica", "RAYSERVICE_GPUS_PER_REPLICA")
+	config.SetDefault("rayServiceGPUsPerReplica", 1)
+	config.BindEnv("rayServiceMaxWorkerReplicas", "RAYSERVICE_MAX_WORKER_REPLICAS")
+	config.SetDefault("rayServiceMaxWorkerReplicas", 16)
+	config.BindEnv("rayServiceMaxGPUsPerWorker", "RAYSERVICE_MAX_GPUS_PER_WORKER")
+	config.SetDefault("rayServiceMaxGPUsPerWorker", 8)
+	config.BindEnv("rayServiceTemplateConfigMap", "RAYSERVICE_TEMPLATE_CONFIGMAP")
+	config.SetDefault("rayServiceTemplateConfigMap", "datatunerx-rayservice-template")
+	config.BindEnv("rayServiceTemplateNamespace", "RAYSERVICE_TEMPLATE_NAMESPACE")
+	config.SetDefault("rayServiceTemplateNamespace", "")
+	config.BindEnv("shutdownTimeout", "SHUTDOWN_TIMEOUT")
+	config.SetDefault("shutdownTimeout", "30s")
+	config.BindEnv("inferenceCallerHeader", "INFERENCE_CALLER_HEADER")
+	config.SetDefault("inferenceCallerHeader", "X-User")
+	config.BindEnv("inferenceUsageConfigMap", "INFERENCE_USAGE_CONFIGMAP")
+	config.SetDefault("inferenceUsageConfigMap", "datatunerx-inference-usage")
+	config.BindEnv("inferenceUsageFlushInterval", "INFERENCE_USAGE_FLUSH_INTERVAL")
+	config.SetDefault("inferenceUsageFlushInterval", "1m")
+	config.BindEnv("inferenceUsageHourlyRetention", "INFERENCE_USAGE_HOURLY_RETENTION")
+	config.SetDefault("inferenceUsageHourlyRetention", "168h")
+	config.BindEnv("inferenceUsageDailyRetention", "INFERENCE_USAGE_DAILY_RETENTION")
+	config.SetDefault("inferenceUsageDailyRetention", "1488h")
+	config.BindEnv("inferenceUsageMaxBuckets", "INFERENCE_USAGE_MAX_BUCKETS")
+	config.SetDefault("inferenceUsageMaxBuckets", 5000)
+	config.BindEnv("inferenceMonthlyTokenQuota", "INFERENCE_MONTHLY_TOKEN_QUOTA")
+	config.SetDefault("inferenceMonthlyTokenQuota", 0)
+	config.BindEnv("inferenceServiceRateLimitRPS", "INFERENCE_SERVICE_RATE_LIMIT_RPS")
+	config.SetDefault("inferenceServiceRateLimitRPS", 0)
+	config.BindEnv("inferenceServiceRateLimitBurst", "INFERENCE_SERVICE_RATE_LIMIT_BURST")
+	config.SetDefault("inferenceServiceRateLimitBurst", 0)
+	config.BindEnv("inferenceServiceMaxInFlight", "INFERENCE_SERVICE_MAX_IN_FLIGHT")
+	config.SetDefault("inferenceServiceMaxInFlight", 0)
+	config.BindEnv("inferenceNamespaceRateLimitRPS", "INFERENCE_NAMESPACE_RATE_LIMIT_RPS")
+	config.SetDefault("inferenceNamespaceRateLimitRPS", 0)
+	config.BindEnv("inferenceNamespaceRateLimitBurst", "INFERENCE_NAMESPACE_RATE_LIMIT_BURST")
+	config.SetDefault("inferenceNamespaceRateLimitBurst", 0)
+	config.BindEnv("inferenceNamespaceMaxInFlight", "INFERENCE_NAMESPACE_MAX_IN_FLIGHT")
+	config.SetDefault("inferenceNamespaceMaxInFlight", 0)
+	config.BindEnv("inferenceCaptureNamespaces", "INFERENCE_CAPTURE_NAMESPACES")
+	config.SetDefault("inferenceCaptureNamespaces", "")
+	config.BindEnv("inferenceCapturePrefix", "INFERENCE_CAPTURE_PREFIX")
+	config.SetDefault("inferenceCapturePrefix", "captures")
+	config.BindEnv("inferenceCaptureBatchSize", "INFERENCE_CAPTURE_BATCH_SIZE")
+	config.SetDefault("inferenceCaptureBatchSize", 100)
+	config.BindEnv("inferenceCaptureBufferSize", "INFERENCE_CAPTURE_BUFFER_SIZE")
+	config.SetDefault("inferenceCaptureBufferSize", 10000)
+	config.BindEnv("inferenceCaptureFlushInterval", "INFERENCE_CAPTURE_FLUSH_INTERVAL")
+	config.SetDefault("inferenceCaptureFlushInterval", "30s")
 }
 
 func GetLevel() string {
@@ -26,6 +165,15 @@ func GetInferenceServiceLabel() string {
 	return config.GetString("inferenceServiceLabel")
 }
 
+// GetWatchNamespace returns the namespace cached by the rayservice informer, empty for all namespaces
+func GetWatchNamespace() string {
+	return config.GetString("watchNamespace")
+}
+
+func GetRayServiceResyncPeriod() time.Duration {
+	return config.GetDuration("rayServiceResyncPeriod")
+}
+
 func GetS3ServiceEndpoint() string {
 	return config.GetString("s3ServiceEndpoint")
 }
@@ -41,3 +189,286 @@ func GetS3ServiceSecretKey() string {
 func GetS3ServiceUseSSL() bool {
 	return config.GetBool("s3ServiceUseSSL")
 }
+
+func GetS3Bucket() string {
+	return config.GetString("s3Bucket")
+}
+
+func GetInferenceCompareTimeout() time.Duration {
+	return config.GetDuration("inferenceCompareTimeout")
+}
+
+func GetBatchInferenceConcurrency() int {
+	return config.GetInt("batchInferenceConcurrency")
+}
+
+func GetBatchInferenceMaxConcurrency() int {
+	return config.GetInt("batchInferenceMaxConcurrency")
+}
+
+func GetBatchInferenceMaxRows() int {
+	return config.GetInt("batchInferenceMaxRows")
+}
+
+func GetBatchInferenceRowTimeout() time.Duration {
+	return config.GetDuration("batchInferenceRowTimeout")
+}
+
+func GetBatchInferenceRetention() time.Duration {
+	return config.GetDuration("batchInferenceRetention")
+}
+
+// GetInferenceCaptureNamespaces returns the namespaces whose inference traffic is captured, "*" matches all
+func GetInferenceCaptureNamespaces() []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(config.GetString("inferenceCaptureNamespaces"), ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+func GetInferenceCapturePrefix() string {
+	return config.GetString("inferenceCapturePrefix")
+}
+
+func GetInferenceCaptureBatchSize() int {
+	return config.GetInt("inferenceCaptureBatchSize")
+}
+
+func GetInferenceCaptureBufferSize() int {
+	return config.GetInt("inferenceCaptureBufferSize")
+}
+
+func GetInferenceCaptureFlushInterval() time.Duration {
+	return config.GetDuration("inferenceCaptureFlushInterval")
+}
+
+// Rate limits and in-flight caps on inference requests, zero disables a limit
+
+func GetInferenceServiceRateLimitRPS() float64 {
+	return config.GetFloat64("inferenceServiceRateLimitRPS")
+}
+
+func GetInferenceServiceRateLimitBurst() int {
+	return config.GetInt("inferenceServiceRateLimitBurst")
+}
+
+func GetInferenceServiceMaxInFlight() int {
+	return config.GetInt("inferenceServiceMaxInFlight")
+}
+
+func GetInferenceNamespaceRateLimitRPS() float64 {
+	return config.GetFloat64("inferenceNamespaceRateLimitRPS")
+}
+
+func GetInferenceNamespaceRateLimitBurst() int {
+	return config.GetInt("inferenceNamespaceRateLimitBurst")
+}
+
+func GetInferenceNamespaceMaxInFlight() int {
+	return config.GetInt("inferenceNamespaceMaxInFlight")
+}
+
+// GetInferenceCallerHeader returns the request header identifying the caller for usage accounting
+func GetInferenceCallerHeader() string {
+	return config.GetString("inferenceCallerHeader")
+}
+
+func GetInferenceUsageConfigMap() string {
+	return config.GetString("inferenceUsageConfigMap")
+}
+
+func GetInferenceUsageFlushInterval() time.Duration {
+	return config.GetDuration("inferenceUsageFlushInterval")
+}
+
+func GetInferenceUsageHourlyRetention() time.Duration {
+	return config.GetDuration("inferenceUsageHourlyRetention")
+}
+
+func GetInferenceUsageDailyRetention() time.Duration {
+	return config.GetDuration("inferenceUsageDailyRetention")
+}
+
+// GetInferenceMonthlyTokenQuota returns the default monthly token quota of a namespace, zero disables it
+func GetInferenceMonthlyTokenQuota() int64 {
+	return config.GetInt64("inferenceMonthlyTokenQuota")
+}
+
+// Upstream transport of the inference proxy. The request timeout bounds non-streaming
+// exchanges only, streams are bounded by the response header timeout and the client.
+
+func GetInferenceDialTimeout() time.Duration {
+	return config.GetDuration("inferenceDialTimeout")
+}
+
+func GetInferenceResponseHeaderTimeout() time.Duration {
+	return config.GetDuration("inferenceResponseHeaderTimeout")
+}
+
+func GetInferenceRequestTimeout() time.Duration {
+	return config.GetDuration("inferenceRequestTimeout")
+}
+
+func GetInferenceMaxIdleConns() int {
+	return config.GetInt("inferenceMaxIdleConns")
+}
+
+func GetInferenceMaxIdleConnsPerHost() int {
+	return config.GetInt("inferenceMaxIdleConnsPerHost")
+}
+
+func GetInferenceIdleConnTimeout() time.Duration {
+	return config.GetDuration("inferenceIdleConnTimeout")
+}
+
+// GetInferenceMaxRetries returns how often a request that could not connect is retried
+func GetInferenceMaxRetries() int {
+	return config.GetInt("inferenceMaxRetries")
+}
+
+func GetInferenceRetryBackoff() time.Duration {
+	return config.GetDuration("inferenceRetryBackoff")
+}
+
+// GetInferenceBreakerFailureThreshold returns the consecutive failures opening a circuit breaker, zero disables breaking
+func GetInferenceBreakerFailureThreshold() int {
+	return config.GetInt("inferenceBreakerFailureThreshold")
+}
+
+func GetInferenceBreakerOpenDuration() time.Duration {
+	return config.GetDuration("inferenceBreakerOpenDuration")
+}
+
+// GetPromptTemplateConfigMap returns the name of the ConfigMap holding the prompt templates of a namespace
+func GetPromptTemplateConfigMap() string {
+	return config.GetString("promptTemplateConfigMap")
+}
+
+// GetInferenceResponseCacheSize returns the number of cached deterministic responses, zero disables the cache
+func GetInferenceResponseCacheSize() int {
+	return config.GetInt("inferenceResponseCacheSize")
+}
+
+func GetInferenceResponseCacheTTL() time.Duration {
+	return config.GetDuration("inferenceResponseCacheTTL")
+}
+
+// GetInferenceRouteConfigMap returns the name of the ConfigMap holding the inference routes of a namespace
+func GetInferenceRouteConfigMap() string {
+	return config.GetString("inferenceRouteConfigMap")
+}
+
+// GetInferenceSessionMaxTurns returns the user/assistant turns kept in a chat session's history
+func GetInferenceSessionMaxTurns() int {
+	return config.GetInt("inferenceSessionMaxTurns")
+}
+
+// GetInferenceSessionMaxTokens returns the estimated tokens kept in a chat session's history, zero disables the bound
+func GetInferenceSessionMaxTokens() int {
+	return config.GetInt("inferenceSessionMaxTokens")
+}
+
+func GetInferenceSessionIdleTimeout() time.Duration {
+	return config.GetDuration("inferenceSessionIdleTimeout")
+}
+
+// GetInferenceWebSocketAllowedOrigins returns the origins allowed to open chat websockets, "*" allows any origin
+// and an empty list only the server's own host
+func GetInferenceWebSocketAllowedOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(config.GetString("inferenceWebSocketAllowedOrigins"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// GetGuardrailConfigMap returns the name of the ConfigMap holding the guardrail configuration of a namespace
+func GetGuardrailConfigMap() string {
+	return config.GetString("guardrailConfigMap")
+}
+
+// GetGuardrailCacheTTL returns how long the guardrails of a namespace are cached before being read again
+func GetGuardrailCacheTTL() time.Duration {
+	return config.GetDuration("guardrailCacheTTL")
+}
+
+// GetInferenceFeedbackPrefix returns the S3 prefix feedback is written under, one sub-prefix per namespace
+func GetInferenceFeedbackPrefix() string {
+	return config.GetString("inferenceFeedbackPrefix")
+}
+
+// GetInferenceFeedbackWindow returns how long after a response feedback can be given on it
+func GetInferenceFeedbackWindow() time.Duration {
+	return config.GetDuration("inferenceFeedbackWindow")
+}
+
+// GetInferenceFeedbackMaxPending returns the number of responses kept for feedback
+func GetInferenceFeedbackMaxPending() int {
+	return config.GetInt("inferenceFeedbackMaxPending")
+}
+
+// GetRayServiceHeadResources returns the default cpu and memory requests and limits of a rayservice head
+func GetRayServiceHeadResources() (cpuRequest, cpuLimit, memoryRequest, memoryLimit string) {
+	return config.GetString("rayServiceHeadCPURequest"), config.GetString("rayServiceHeadCPULimit"),
+		config.GetString("rayServiceHeadMemoryRequest"), config.GetString("rayServiceHeadMemoryLimit")
+}
+
+// GetRayServiceWorkerResources returns the default cpu and memory requests and limits of a rayservice worker
+func GetRayServiceWorkerResources() (cpuRequest, cpuLimit, memoryRequest, memoryLimit string) {
+	return config.GetString("rayServiceWorkerCPURequest"), config.GetString("rayServiceWorkerCPULimit"),
+		config.GetString("rayServiceWorkerMemoryRequest"), config.GetString("rayServiceWorkerMemoryLimit")
+}
+
+func GetRayServiceWorkerReplicas() int32 {
+	return config.GetInt32("rayServiceWorkerReplicas")
+}
+
+// GetRayServiceGPUsPerWorker returns the default nvidia.com/gpu limit of a rayservice worker
+func GetRayServiceGPUsPerWorker() int64 {
+	return config.GetInt64("rayServiceGPUsPerWorker")
+}
+
+func GetRayServiceServeReplicas() int32 {
+	return config.GetInt32("rayServiceServeReplicas")
+}
+
+// GetRayServiceGPUsPerReplica returns the default GPUs of a serve replica, fractions let replicas share a GPU
+func GetRayServiceGPUsPerReplica() float64 {
+	return config.GetFloat64("rayServiceGPUsPerReplica")
+}
+
+// GetRayServiceMaxWorkerReplicas returns the largest worker max replicas a rayservice may be created or scaled to
+func GetRayServiceMaxWorkerReplicas() int32 {
+	return config.GetInt32("rayServiceMaxWorkerReplicas")
+}
+
+// GetRayServiceMaxGPUsPerWorker returns the largest nvidia.com/gpu limit a rayservice worker may be created with
+func GetRayServiceMaxGPUsPerWorker() int64 {
+	return config.GetInt64("rayServiceMaxGPUsPerWorker")
+}
+
+// GetRayServiceTemplateConfigMap returns the name of the ConfigMap holding the rayservice manifest template
+func GetRayServiceTemplateConfigMap() string {
+	return config.GetString("rayServiceTemplateConfigMap")
+}
+
+// GetRayServiceTemplateNamespace returns the namespace of the cluster-wide rayservice template, empty disables it
+func GetRayServiceTemplateNamespace() string {
+	return config.GetString("rayServiceTemplateNamespace")
+}
+
+// GetShutdownTimeout returns how long the server waits for requests in flight and buffered records on shutdown
+func GetShutdownTimeout() time.Duration {
+	return config.GetDuration("shutdownTimeout")
+}
+
+// GetInferenceUsageMaxBuckets returns the most usage buckets stored per namespace. A bucket takes about 150 bytes,
+// the default keeps the usage ConfigMap well below the 1 MiB object size limit.
+func GetInferenceUsageMaxBuckets() int {
+	return config.GetInt("inferenceUsageMaxBuckets")
+}
@@ -0,0 +1,453 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/s3"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+const (
+	BatchJobPending   = "Pending"
+	BatchJobRunning   = "Running"
+	BatchJobSucceeded = "Succeeded"
+	BatchJobFailed    = "Failed"
+	BatchJobCancelled = "Cancelled"
+)
+
+// BatchInferenceHandler runs batch inference jobs over JSONL files in S3.
+// Jobs are kept in memory and are lost when the server restarts.
+type BatchInferenceHandler struct {
+	InferenceHandler *InferenceHandler
+	S3Client         s3.S3Client
+
+	mu   sync.Mutex
+	jobs map[string]*BatchJob
+}
+
+// NewBatchInferenceHandler creates a new instance of BatchInferenceHandler
+func NewBatchInferenceHandler(inferenceHandler *InferenceHandler, s3Client s3.S3Client) *BatchInferenceHandler {
+	return &BatchInferenceHandler{
+		InferenceHandler: inferenceHandler,
+		S3Client:         s3Client,
+		jobs:             make(map[string]*BatchJob),
+	}
+}
+
+// BatchInferenceRequest is the body accepted by SubmitBatchJobHandler
+type BatchInferenceRequest struct {
+	InputURL    string `json:"inputUrl"`
+	Service     string `json:"service"`
+	Concurrency int    `json:"concurrency"`
+	GenerationParams
+}
+
+// BatchInferenceRow is one line of the input JSONL file
+type BatchInferenceRow struct {
+	ID string `json:"id"`
+	InferenceChatRequest
+}
+
+// BatchInferenceResult is one line of the results JSONL file
+type BatchInferenceResult struct {
+	Index       int    `json:"index"`
+	ID          string `json:"id,omitempty"`
+	Output      string `json:"output,omitempty"`
+	TokenLength string `json:"tokenLength,omitempty"`
+	ElapsedTime string `json:"elapsedTime,omitempty"`
+	TokenPerSec string `json:"tokenPerSec,omitempty"`
+	Cached      bool   `json:"cached,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// BatchJob is a batch inference job and its progress
+type BatchJob struct {
+	ID          string     `json:"id"`
+	Namespace   string     `json:"namespace"`
+	Service     string     `json:"service"`
+	Caller      string     `json:"caller"`
+	InputURL    string     `json:"inputUrl"`
+	OutputURL   string     `json:"outputUrl,omitempty"`
+	Concurrency int        `json:"concurrency"`
+	Status      string     `json:"status"`
+	Total       int        `json:"total"`
+	Completed   int        `json:"completed"`
+	Failed      int        `json:"failed"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+
+	params GenerationParams
+	cancel context.CancelFunc
+}
+
+// SubmitBatchJobHandler validates the request and starts the job in the background
+func (bh *BatchInferenceHandler) SubmitBatchJobHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+
+	var requestBody BatchInferenceRequest
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if requestBody.Service == "" {
+		writeError(c, http.StatusBadRequest, "Missing 'service' in the request body")
+		return
+	}
+	if _, _, err := parseBatchInputURL(requestBody.InputURL); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid 'inputUrl': %v", err))
+		return
+	}
+	if err := requestBody.GenerationParams.Validate(); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid generation parameter: %v", err))
+		return
+	}
+	concurrency := requestBody.Concurrency
+	if concurrency <= 0 {
+		concurrency = config.GetBatchInferenceConcurrency()
+	}
+	if concurrency > config.GetBatchInferenceMaxConcurrency() {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("'concurrency' must be at most %d", config.GetBatchInferenceMaxConcurrency()))
+		return
+	}
+	// 任务在后台读写 S3，S3 未初始化时不能提交
+	if bh.S3Client.Client == nil {
+		writeError(c, http.StatusServiceUnavailable, "Batch inference is not available, S3 is not configured")
+		return
+	}
+	if _, err := bh.InferenceHandler.getServeService(c.Request.Context(), namespace, requestBody.Service); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to get rayservice: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &BatchJob{
+		ID:          rand.String(10),
+		Namespace:   namespace,
+		Service:     requestBody.Service,
+		Caller:      callerIdentity(c),
+		InputURL:    requestBody.InputURL,
+		Concurrency: concurrency,
+		Status:      BatchJobPending,
+		CreatedAt:   time.Now(),
+		params:      requestBody.GenerationParams,
+		cancel:      cancel,
+	}
+
+	bh.mu.Lock()
+	bh.pruneLocked()
+	bh.jobs[job.ID] = job
+	snapshot := *job
+	bh.mu.Unlock()
+
+	go bh.run(ctx, job)
+
+	c.JSON(http.StatusAccepted, snapshot)
+}
+
+// ListBatchJobsHandler lists the batch jobs of the namespace
+func (bh *BatchInferenceHandler) ListBatchJobsHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+
+	bh.mu.Lock()
+	jobs := make([]BatchJob, 0, len(bh.jobs))
+	for _, job := range bh.jobs {
+		if job.Namespace == namespace {
+			jobs = append(jobs, *job)
+		}
+	}
+	bh.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetBatchJobHandler returns the status and progress of a batch job
+func (bh *BatchInferenceHandler) GetBatchJobHandler(c *gin.Context) {
+	job, ok := bh.getJob(c.Param("namespace"), c.Param("jobId"))
+	if !ok {
+		writeError(c, http.StatusNotFound, fmt.Sprintf("Batch job %s not found", c.Param("jobId")))
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelBatchJobHandler cancels a pending or running batch job, results processed so far are still written
+func (bh *BatchInferenceHandler) CancelBatchJobHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	jobID := c.Param("jobId")
+
+	bh.mu.Lock()
+	job, ok := bh.jobs[jobID]
+	if !ok || job.Namespace != namespace {
+		bh.mu.Unlock()
+		writeError(c, http.StatusNotFound, fmt.Sprintf("Batch job %s not found", jobID))
+		return
+	}
+	if job.Status != BatchJobPending && job.Status != BatchJobRunning {
+		status := job.Status
+		bh.mu.Unlock()
+		writeError(c, http.StatusConflict, fmt.Sprintf("Batch job %s is already %s", jobID, status))
+		return
+	}
+	job.cancel()
+	snapshot := *job
+	bh.mu.Unlock()
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func (bh *BatchInferenceHandler) getJob(namespace, jobID string) (BatchJob, bool) {
+	bh.mu.Lock()
+	defer bh.mu.Unlock()
+	job, ok := bh.jobs[jobID]
+	if !ok || job.Namespace != namespace {
+		return BatchJob{}, false
+	}
+	return *job, true
+}
+
+// pruneLocked drops finished jobs older than the retention period, bh.mu must be held
+func (bh *BatchInferenceHandler) pruneLocked() {
+	retention := config.GetBatchInferenceRetention()
+	for id, job := range bh.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > retention {
+			delete(bh.jobs, id)
+		}
+	}
+}
+
+// update applies fn to the job under the handler lock
+func (bh *BatchInferenceHandler) update(job *BatchJob, fn func(job *BatchJob)) {
+	bh.mu.Lock()
+	defer bh.mu.Unlock()
+	fn(job)
+}
+
+// finish records the final state of the job
+func (bh *BatchInferenceHandler) finish(job *BatchJob, status, errMsg string) {
+	bh.update(job, func(job *BatchJob) {
+		now := time.Now()
+		job.Status = status
+		job.Error = errMsg
+		job.FinishedAt = &now
+	})
+	if errMsg != "" {
+		logging.ZLogger.Errorf("Batch job %s/%s %s: %s", job.Namespace, job.ID, status, errMsg)
+	} else {
+		logging.ZLogger.Infof("Batch job %s/%s %s", job.Namespace, job.ID, status)
+	}
+}
+
+// run reads the input rows, sends them to the rayservice with bounded concurrency and writes the results back to S3
+func (bh *BatchInferenceHandler) run(ctx context.Context, job *BatchJob) {
+	defer job.cancel()
+
+	rows, err := bh.readRows(ctx, job.InputURL)
+	if ctx.Err() != nil {
+		// 任务在读取输入时被取消
+		bh.finish(job, BatchJobCancelled, "")
+		return
+	}
+	if err != nil {
+		bh.finish(job, BatchJobFailed, fmt.Sprintf("Failed to read input: %v", err))
+		return
+	}
+	bh.update(job, func(job *BatchJob) {
+		now := time.Now()
+		job.Status = BatchJobRunning
+		job.StartedAt = &now
+		job.Total = len(rows)
+	})
+
+	templates := bh.cachedPromptTemplates(ctx, job.Namespace)
+	results := make([]BatchInferenceResult, len(rows))
+	submitted := len(rows)
+	semaphore := make(chan struct{}, job.Concurrency)
+	var wg sync.WaitGroup
+	for i, row := range rows {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			submitted = i
+			break
+		}
+		wg.Add(1)
+		go func(i int, row batchRow) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[i] = bh.runRow(ctx, job, i, row, templates)
+			bh.update(job, func(job *BatchJob) {
+				job.Completed++
+				if results[i].Error != "" {
+					job.Failed++
+				}
+			})
+		}(i, row)
+	}
+	wg.Wait()
+
+	outputURL, err := bh.writeResults(job, results[:submitted])
+	if err != nil {
+		bh.finish(job, BatchJobFailed, fmt.Sprintf("Failed to write results: %v", err))
+		return
+	}
+	bh.update(job, func(job *BatchJob) {
+		job.OutputURL = outputURL
+	})
+	if ctx.Err() != nil {
+		bh.finish(job, BatchJobCancelled, "")
+		return
+	}
+	bh.finish(job, BatchJobSucceeded, "")
+}
+
+// parseBatchInputURL parses the input URL, which must be in the configured bucket:
+// the object is read with the server's credentials
+func parseBatchInputURL(inputURL string) (string, string, error) {
+	bucketName, objectName, err := s3.ParseS3URL(inputURL)
+	if err != nil {
+		return "", "", err
+	}
+	if bucketName != config.GetS3Bucket() {
+		return "", "", fmt.Errorf("input must be in bucket %s", config.GetS3Bucket())
+	}
+	return bucketName, objectName, nil
+}
+
+// batchRow is a parsed input line, or the error that prevented parsing it
+type batchRow struct {
+	row BatchInferenceRow
+	err error
+}
+
+// readRows downloads the input JSONL object and parses every non-empty line
+func (bh *BatchInferenceHandler) readRows(ctx context.Context, inputURL string) ([]batchRow, error) {
+	bucketName, objectName, err := parseBatchInputURL(inputURL)
+	if err != nil {
+		return nil, err
+	}
+	object, err := bh.S3Client.Client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	maxRows := config.GetBatchInferenceMaxRows()
+	var rows []batchRow
+	scanner := bufio.NewScanner(object)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(rows) >= maxRows {
+			return nil, fmt.Errorf("input has more than %d rows", maxRows)
+		}
+		var row BatchInferenceRow
+		err := json.Unmarshal(line, &row)
+		rows = append(rows, batchRow{row: row, err: err})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("input is empty")
+	}
+	return rows, nil
+}
+
+// runRow sends one row to the rayservice, job-level generation parameters fill those the row leaves unset
+func (bh *BatchInferenceHandler) runRow(ctx context.Context, job *BatchJob, index int, row batchRow, templates promptTemplateLookup) BatchInferenceResult {
+	result := BatchInferenceResult{Index: index, ID: row.row.ID}
+	if row.err != nil {
+		result.Error = fmt.Sprintf("Failed to parse row: %v", row.err)
+		return result
+	}
+	if err := applyPromptTemplate(templates, &row.row.InferenceChatRequest); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	messages, err := buildChatMessages(row.row.InferenceChatRequest)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	params := row.row.GenerationParams.WithDefaults(job.params)
+	if err := params.Validate(); err != nil {
+		result.Error = fmt.Sprintf("Invalid generation parameter: %v", err)
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.GetBatchInferenceRowTimeout())
+	defer cancel()
+	// 批量任务自身已限制并发，不占用交互请求的限流配额
+	compared := bh.InferenceHandler.compareOne(ctx, job.Namespace, job.Service, job.Caller, messages, params, false)
+	result.Output = compared.Output
+	result.TokenLength = compared.TokenLength
+	result.ElapsedTime = compared.ElapsedTime
+	result.TokenPerSec = compared.TokenPerSec
+	result.Cached = compared.Cached
+	result.Error = compared.Error
+	return result
+}
+
+// cachedPromptTemplates looks each prompt template up once for the whole job
+func (bh *BatchInferenceHandler) cachedPromptTemplates(ctx context.Context, namespace string) promptTemplateLookup {
+	lookup := promptTemplatesOf(ctx, bh.InferenceHandler.KubeClients, namespace)
+	type cached struct {
+		promptTemplate *PromptTemplate
+		err            error
+	}
+	var mu sync.Mutex
+	templates := make(map[string]cached)
+	return func(name string) (*PromptTemplate, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if entry, ok := templates[name]; ok {
+			return entry.promptTemplate, entry.err
+		}
+		promptTemplate, err := lookup(name)
+		templates[name] = cached{promptTemplate: promptTemplate, err: err}
+		return promptTemplate, err
+	}
+}
+
+// writeResults uploads the results as JSONL next to the other batch outputs of the namespace
+func (bh *BatchInferenceHandler) writeResults(job *BatchJob, results []BatchInferenceResult) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			return "", err
+		}
+	}
+
+	bucketName := config.GetS3Bucket()
+	objectName := fmt.Sprintf("batch/%s/%s/results.jsonl", job.Namespace, job.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if err := bh.S3Client.PutBytes(ctx, bucketName, objectName, buf.Bytes(), "application/x-ndjson"); err != nil {
+		return "", err
+	}
+	return formatS3URL(bh.S3Client.ObjectURL(bucketName, objectName))
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/s3"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSubmitBatchJobWithoutS3(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bh := NewBatchInferenceHandler(&InferenceHandler{}, s3.S3Client{})
+	body := fmt.Sprintf(`{"service": "llama", "inputUrl": "s3://%s/batch/input.jsonl"}`, config.GetS3Bucket())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/namespaces/default/inference/batch", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "namespace", Value: "default"}}
+
+	bh.SubmitBatchJobHandler(c)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 without an S3 client, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var status map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil || status["reason"] != "ServiceUnavailable" {
+		t.Errorf("Expected a ServiceUnavailable Status body, got %s", recorder.Body.String())
+	}
+	if len(bh.jobs) != 0 {
+		t.Errorf("Expected no job to be created, got %d", len(bh.jobs))
+	}
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"path"
+	"time"
+
+	"datatunerx-server/config"
+
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+)
+
+// annotationCapture turns request/response capture on ("true") or off ("false") for a rayservice,
+// overriding the namespace setting
+const annotationCapture = "inference.datatunerx.io/capture"
+
+// annotationLLMCheckpoint names the LLMCheckpoint a rayservice serves
+const annotationLLMCheckpoint = "core.datatunerx.io/llmCheckpoint"
+
+// InferenceCaptureRecord is one captured inference exchange, written as a JSONL line
+type InferenceCaptureRecord struct {
+	Timestamp     time.Time              `json:"timestamp"`
+	Namespace     string                 `json:"namespace"`
+	Service       string                 `json:"service"`
+	LLMCheckpoint string                 `json:"llmCheckpoint,omitempty"`
+	Messages      []InferenceBodyMessage `json:"messages"`
+	Output        string                 `json:"output"`
+	Parameters    GenerationParams       `json:"parameters"`
+	TokenLength   string                 `json:"tokenLength,omitempty"`
+	ElapsedTime   string                 `json:"elapsedTime,omitempty"`
+	TokenPerSec   string                 `json:"tokenPerSec,omitempty"`
+}
+
+// captureEnabled reports whether exchanges with the rayservice should be captured
+func captureEnabled(rayService *rayv1.RayService) bool {
+	switch rayService.Annotations[annotationCapture] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	for _, namespace := range config.GetInferenceCaptureNamespaces() {
+		if namespace == "*" || namespace == rayService.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// capture queues the exchange for the capture writer, it never blocks the request
+func (Ih *InferenceHandler) capture(rayService *rayv1.RayService, requestBody InferenceBody, resp InferenceProcessedResponse) {
+	if Ih.CaptureWriter == nil || !captureEnabled(rayService) {
+		return
+	}
+	Ih.CaptureWriter.Write(path.Join(config.GetInferenceCapturePrefix(), rayService.Namespace, rayService.Name), InferenceCaptureRecord{
+		Timestamp:     time.Now(),
+		Namespace:     rayService.Namespace,
+		Service:       rayService.Name,
+		LLMCheckpoint: rayService.Annotations[annotationLLMCheckpoint],
+		Messages:      requestBody.Messages,
+		Output:        resp.Output,
+		Parameters:    requestBody.GenerationParams,
+		TokenLength:   resp.TokenLength,
+		ElapsedTime:   resp.ElapsedTime,
+		TokenPerSec:   resp.TokenPerSec,
+	})
+}
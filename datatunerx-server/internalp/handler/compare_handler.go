@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"datatunerx-server/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCompareServices bounds the fan-out of a single compare request
+const maxCompareServices = 10
+
+// InferenceCompareRequest is the body accepted by InferenceCompareHandler
+type InferenceCompareRequest struct {
+	InferenceChatRequest
+	Services       []string `json:"services"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+}
+
+// InferenceCompareResult is the outcome of the prompt on one rayservice
+type InferenceCompareResult struct {
+	Service     string `json:"service"`
+	Output      string `json:"output,omitempty"`
+	LatencyMs   int64  `json:"latencyMs"`
+	TokenLength string `json:"tokenLength,omitempty"`
+	ElapsedTime string `json:"elapsedTime,omitempty"`
+	TokenPerSec string `json:"tokenPerSec,omitempty"`
+	Cached      bool   `json:"cached"`
+	Error       string `json:"error,omitempty"`
+}
+
+// InferenceCompareHandler sends one prompt to several rayservices concurrently and returns the results side by side
+func (Ih *InferenceHandler) InferenceCompareHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+
+	var requestBody InferenceCompareRequest
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if len(requestBody.Services) == 0 {
+		writeError(c, http.StatusBadRequest, "Missing 'services' in the request body")
+		return
+	}
+	if len(requestBody.Services) > maxCompareServices {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("At most %d services can be compared at once", maxCompareServices))
+		return
+	}
+	seen := make(map[string]struct{}, len(requestBody.Services))
+	for _, service := range requestBody.Services {
+		if service == "" {
+			writeError(c, http.StatusBadRequest, "Service names must be non-empty")
+			return
+		}
+		if _, ok := seen[service]; ok {
+			writeError(c, http.StatusBadRequest, fmt.Sprintf("Duplicate service %q", service))
+			return
+		}
+		seen[service] = struct{}{}
+	}
+	if requestBody.TimeoutSeconds < 0 {
+		writeError(c, http.StatusBadRequest, "'timeoutSeconds' must not be negative")
+		return
+	}
+
+	if err := applyPromptTemplate(promptTemplatesOf(c.Request.Context(), Ih.KubeClients, namespace), &requestBody.InferenceChatRequest); err != nil {
+		writeError(c, applyPromptTemplateStatus(err), err.Error())
+		return
+	}
+	messages, err := buildChatMessages(requestBody.InferenceChatRequest)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := requestBody.GenerationParams.Validate(); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid generation parameter: %v", err))
+		return
+	}
+
+	timeout := config.GetInferenceCompareTimeout()
+	if requestBody.TimeoutSeconds > 0 {
+		timeout = time.Duration(requestBody.TimeoutSeconds) * time.Second
+	}
+	// 不超过单个推理请求的超时，超出部分上游客户端也不会等待
+	if maxTimeout := config.GetInferenceRequestTimeout(); maxTimeout > 0 && timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+
+	caller := callerIdentity(c)
+	results := make([]InferenceCompareResult, len(requestBody.Services))
+	var wg sync.WaitGroup
+	for i, service := range requestBody.Services {
+		wg.Add(1)
+		go func(i int, service string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			defer cancel()
+			results[i] = Ih.compareOne(ctx, namespace, service, caller, messages, requestBody.GenerationParams, true)
+		}(i, service)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// compareOne resolves a rayservice the same way InferenceChatHandler does and runs the prompt on it,
+// taking a slot from the rate limiters when enforceLimits is set. Tokens are accounted to caller.
+func (Ih *InferenceHandler) compareOne(ctx context.Context, namespace, rayServiceName, caller string, messages []InferenceBodyMessage, params GenerationParams, enforceLimits bool) (result InferenceCompareResult) {
+	result.Service = rayServiceName
+	start := time.Now()
+	defer func() {
+		result.LatencyMs = time.Since(start).Milliseconds()
+	}()
+
+	// 命名空间护栏同样作用于对比与批量推理
+	rails, err := Ih.guardrailsOf(ctx, namespace)
+	if err != nil {
+		result.Error = fmt.Sprintf("Failed to load guardrails: %v", err)
+		return result
+	}
+	if messages, err = rails.filterMessages(messages); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	rayService, err := Ih.getServeService(ctx, namespace, rayServiceName)
+	if err != nil {
+		result.Error = fmt.Sprintf("Failed to get rayservice: %v", err)
+		return result
+	}
+	if err := checkServeReady(rayService); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if err := Ih.checkQuota(ctx, namespace); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if enforceLimits {
+		release, err := Ih.acquireLimits(rayService)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		defer release()
+	}
+	transferBody := InferenceBody{
+		Model:            rayServiceName,
+		Messages:         messages,
+		GenerationParams: params.WithDefaults(generationDefaults(rayService)),
+	}
+	targetServiceURL := serveServiceURL(rayService.Spec.ServeService.Name, namespace, "/chat/completions")
+	resp, cached, err := Ih.forwardCached(ctx, rayService, targetServiceURL, transferBody, false)
+	if err != nil {
+		result.Error = fmt.Sprintf("Failed to forward request: %v", err)
+		return result
+	}
+	if !cached {
+		Ih.recordUsage(rayService, caller, resp.Usage)
+	}
+	if resp.Output, err = rails.filterOutput(resp.Output); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Cached = cached
+	result.Output = resp.Output
+	result.TokenLength = resp.TokenLength
+	result.ElapsedTime = resp.ElapsedTime
+	result.TokenPerSec = resp.TokenPerSec
+	return result
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxEmbeddingInputs bounds the texts embedded by a single request
+const maxEmbeddingInputs = 256
+
+// UnsupportedRouteError is returned when the deployment does not serve a route, e.g. a chat-only model asked for embeddings
+type UnsupportedRouteError struct {
+	Service string
+	Route   string
+}
+
+func (e *UnsupportedRouteError) Error() string {
+	return fmt.Sprintf("rayservice %s does not serve %s", e.Service, e.Route)
+}
+
+// InferenceCompletionRequest is the body accepted by InferenceCompletionsHandler
+type InferenceCompletionRequest struct {
+	Prompt string `json:"prompt"`
+	GenerationParams
+}
+
+// InferenceCompletionBody is the raw completion request sent to the deployment, no chat template is applied
+type InferenceCompletionBody struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	GenerationParams
+}
+
+type InferenceCompletionChoice struct {
+	Index        int    `json:"index"`
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason"`
+}
+
+type InferenceCompletionResponse struct {
+	ID      string                      `json:"id"`
+	Object  string                      `json:"object"`
+	Created int64                       `json:"created"`
+	Model   string                      `json:"model"`
+	Choices []InferenceCompletionChoice `json:"choices"`
+	Usage   InferenceUsage              `json:"usage"`
+}
+
+// EmbeddingInput accepts either a single string or an array of strings
+type EmbeddingInput []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (e *EmbeddingInput) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*e = EmbeddingInput{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("input must be a string or an array of strings")
+	}
+	*e = multiple
+	return nil
+}
+
+// InferenceEmbeddingRequest is the body accepted by InferenceEmbeddingsHandler
+type InferenceEmbeddingRequest struct {
+	Input EmbeddingInput `json:"input"`
+}
+
+// InferenceEmbeddingBody is the embedding request sent to the deployment
+type InferenceEmbeddingBody struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type InferenceEmbedding struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// InferenceEmbeddingUsage accepts the token counts as numbers or numeric strings
+type InferenceEmbeddingUsage struct {
+	PromptTokens json.Number `json:"prompt_tokens"`
+	TotalTokens  json.Number `json:"total_tokens"`
+}
+
+type InferenceEmbeddingResponse struct {
+	Object string                  `json:"object"`
+	Model  string                  `json:"model"`
+	Data   []InferenceEmbedding    `json:"data"`
+	Usage  InferenceEmbeddingUsage `json:"usage"`
+}
+
+// InferenceEmbeddingsResult is returned by InferenceEmbeddingsHandler, one embedding per input in order
+type InferenceEmbeddingsResult struct {
+	Embeddings  [][]float64 `json:"embeddings"`
+	Dimensions  int         `json:"dimensions"`
+	TokenLength string      `json:"tokenLength"`
+}
+
+// InferenceCompletionsHandler forwards a raw text completion to the rayservice
+func (Ih *InferenceHandler) InferenceCompletionsHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	rayServiceName := c.Param("serviceName")
+
+	var requestBody InferenceCompletionRequest
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if requestBody.Prompt == "" {
+		writeError(c, http.StatusBadRequest, "Missing 'prompt' in the request body")
+		return
+	}
+	if err := requestBody.GenerationParams.Validate(); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid generation parameter: %v", err))
+		return
+	}
+
+	rails, ok := Ih.loadGuardrails(c, namespace)
+	if !ok {
+		return
+	}
+	prompts, err := rails.filterInput([]string{requestBody.Prompt})
+	if err != nil {
+		writeInferenceError(c, err)
+		return
+	}
+
+	rayService, release, ok := Ih.admit(c, namespace, rayServiceName)
+	if !ok {
+		return
+	}
+	defer release()
+
+	targetServiceURL := serveServiceURL(rayService.Spec.ServeService.Name, namespace, "/completions")
+	var response InferenceCompletionResponse
+	err = postUpstream(c.Request.Context(), targetServiceURL, InferenceCompletionBody{
+		Model:            rayServiceName,
+		Prompt:           prompts[0],
+		GenerationParams: requestBody.GenerationParams.WithDefaults(generationDefaults(rayService)),
+	}, &response)
+	if err == nil && len(response.Choices) == 0 {
+		err = &UpstreamError{Message: "response contains no choices"}
+	}
+	if err != nil {
+		writeInferenceError(c, unsupportedRoute(err, rayServiceName, "completions"))
+		return
+	}
+	Ih.recordUsage(rayService, callerIdentity(c), response.Usage)
+	output, err := rails.filterOutput(response.Choices[0].Text)
+	if err != nil {
+		writeInferenceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, InferenceProcessedResponse{
+		Output:      output,
+		TokenLength: response.Usage.TotalTokens,
+		ElapsedTime: response.Usage.ElapsedTIme,
+		TokenPerSec: response.Usage.TokenPerSec,
+	})
+}
+
+// InferenceEmbeddingsHandler returns the embeddings of one or more texts from the rayservice
+func (Ih *InferenceHandler) InferenceEmbeddingsHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	rayServiceName := c.Param("serviceName")
+
+	var requestBody InferenceEmbeddingRequest
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if len(requestBody.Input) == 0 {
+		writeError(c, http.StatusBadRequest, "Missing 'input' in the request body")
+		return
+	}
+	if len(requestBody.Input) > maxEmbeddingInputs {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("At most %d inputs can be embedded at once", maxEmbeddingInputs))
+		return
+	}
+	for i, input := range requestBody.Input {
+		if input == "" {
+			writeError(c, http.StatusBadRequest, fmt.Sprintf("input[%d] must be a non-empty string", i))
+			return
+		}
+	}
+
+	// 嵌入只有输入侧护栏
+	rails, ok := Ih.loadGuardrails(c, namespace)
+	if !ok {
+		return
+	}
+	inputs, err := rails.filterInput(requestBody.Input)
+	if err != nil {
+		writeInferenceError(c, err)
+		return
+	}
+
+	rayService, release, ok := Ih.admit(c, namespace, rayServiceName)
+	if !ok {
+		return
+	}
+	defer release()
+
+	targetServiceURL := serveServiceURL(rayService.Spec.ServeService.Name, namespace, "/embeddings")
+	var response InferenceEmbeddingResponse
+	err = postUpstream(c.Request.Context(), targetServiceURL, InferenceEmbeddingBody{
+		Model: rayServiceName,
+		Input: inputs,
+	}, &response)
+	if err == nil && len(response.Data) != len(requestBody.Input) {
+		err = &UpstreamError{Message: fmt.Sprintf("expected %d embeddings, got %d", len(requestBody.Input), len(response.Data))}
+	}
+	if err != nil {
+		writeInferenceError(c, unsupportedRoute(err, rayServiceName, "embeddings"))
+		return
+	}
+	Ih.recordUsage(rayService, callerIdentity(c), InferenceUsage{
+		PromptTokens: response.Usage.PromptTokens.String(),
+		TotalTokens:  response.Usage.TotalTokens.String(),
+	})
+
+	// 按 index 排列，与输入顺序一致
+	sort.SliceStable(response.Data, func(i, j int) bool {
+		return response.Data[i].Index < response.Data[j].Index
+	})
+	result := InferenceEmbeddingsResult{
+		Embeddings:  make([][]float64, 0, len(response.Data)),
+		TokenLength: response.Usage.TotalTokens.String(),
+	}
+	for _, embedding := range response.Data {
+		result.Embeddings = append(result.Embeddings, embedding.Embedding)
+	}
+	if len(result.Embeddings) > 0 {
+		result.Dimensions = len(result.Embeddings[0])
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// unsupportedRoute turns a 404 or 405 from the deployment into an UnsupportedRouteError
+func unsupportedRoute(err error, service, route string) error {
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) && (upstream.StatusCode == http.StatusNotFound || upstream.StatusCode == http.StatusMethodNotAllowed) {
+		return &UnsupportedRouteError{Service: service, Route: route}
+	}
+	return err
+}
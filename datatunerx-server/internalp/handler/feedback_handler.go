@@ -0,0 +1,354 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/s3"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+const (
+	FeedbackThumbUp   = "up"
+	FeedbackThumbDown = "down"
+)
+
+// InferenceExchange is a served inference response, kept in memory so that feedback can be given on it
+type InferenceExchange struct {
+	ResponseID    string                 `json:"responseId"`
+	Namespace     string                 `json:"namespace"`
+	Service       string                 `json:"service"`
+	LLMCheckpoint string                 `json:"llmCheckpoint,omitempty"`
+	Caller        string                 `json:"caller"`
+	Messages      []InferenceBodyMessage `json:"messages"`
+	Output        string                 `json:"output"`
+	Parameters    GenerationParams       `json:"parameters"`
+	RespondedAt   time.Time              `json:"respondedAt"`
+}
+
+// InferenceFeedbackRequest is the body accepted by SubmitFeedbackHandler, at least one of the feedback fields must be set
+type InferenceFeedbackRequest struct {
+	ResponseID string `json:"responseId"`
+	Thumb      string `json:"thumb,omitempty"`
+	Rating     *int   `json:"rating,omitempty"`
+	Correction string `json:"correction,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+}
+
+// InferenceFeedbackRecord is a feedback together with the exchange it was given on, written as a JSONL line
+type InferenceFeedbackRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Thumb      string    `json:"thumb,omitempty"`
+	Rating     *int      `json:"rating,omitempty"`
+	Correction string    `json:"correction,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	InferenceExchange
+}
+
+// PreferencePair is a DPO style training example: for the prompt, chosen is preferred over rejected
+type PreferencePair struct {
+	Prompt   string                 `json:"prompt"`
+	Chosen   string                 `json:"chosen"`
+	Rejected string                 `json:"rejected"`
+	History  []InferenceBodyMessage `json:"history,omitempty"`
+}
+
+// Validate checks the response ID and that the feedback carries a thumb, a rating, a correction or a comment
+func (r *InferenceFeedbackRequest) Validate() error {
+	if r.ResponseID == "" {
+		return fmt.Errorf("missing 'responseId' in the request body")
+	}
+	if r.Thumb != "" && r.Thumb != FeedbackThumbUp && r.Thumb != FeedbackThumbDown {
+		return fmt.Errorf("'thumb' must be %q or %q", FeedbackThumbUp, FeedbackThumbDown)
+	}
+	if r.Rating != nil && (*r.Rating < 1 || *r.Rating > 5) {
+		return fmt.Errorf("'rating' must be between 1 and 5")
+	}
+	if r.Thumb == "" && r.Rating == nil && r.Correction == "" && r.Comment == "" {
+		return fmt.Errorf("feedback must set at least one of 'thumb', 'rating', 'correction' or 'comment'")
+	}
+	return nil
+}
+
+// newResponseID returns the ID feedback on a response refers to
+func newResponseID() string {
+	return "resp-" + rand.String(20)
+}
+
+// rememberExchange keeps the exchange for feedback under the response ID
+func (Ih *InferenceHandler) rememberExchange(responseID string, rayService *rayv1.RayService, caller string, requestBody InferenceBody, output string) {
+	exchange := InferenceExchange{
+		ResponseID:    responseID,
+		Namespace:     rayService.Namespace,
+		Service:       rayService.Name,
+		LLMCheckpoint: rayService.Annotations[annotationLLMCheckpoint],
+		Caller:        caller,
+		Messages:      requestBody.Messages,
+		Output:        output,
+		Parameters:    requestBody.GenerationParams,
+		RespondedAt:   time.Now().UTC(),
+	}
+	Ih.Exchanges.Add(exchange.ResponseID, rayService.Namespace, exchange)
+}
+
+// FeedbackHandler collects human feedback on inference responses and exports it as preference data.
+// Responses are kept in memory for the configured feedback window, feedback has to reach the replica that served the response.
+type FeedbackHandler struct {
+	InferenceHandler *InferenceHandler
+	S3Client         s3.S3Client
+}
+
+// NewFeedbackHandler creates a new instance of FeedbackHandler
+func NewFeedbackHandler(inferenceHandler *InferenceHandler, s3Client s3.S3Client) *FeedbackHandler {
+	return &FeedbackHandler{
+		InferenceHandler: inferenceHandler,
+		S3Client:         s3Client,
+	}
+}
+
+// feedbackPrefix is the S3 prefix of the feedback of a namespace
+func feedbackPrefix(namespace string) string {
+	return path.Join(config.GetInferenceFeedbackPrefix(), namespace)
+}
+
+// feedbackObjectName is the S3 object a feedback record is stored in, laid out like the capture objects
+func feedbackObjectName(namespace string, at time.Time) string {
+	return fmt.Sprintf("%s/%s/%d-%s.jsonl", feedbackPrefix(namespace), at.Format("2006/01/02"), at.UnixNano(), rand.String(6))
+}
+
+// SubmitFeedbackHandler records feedback on a response served in the namespace
+func (fh *FeedbackHandler) SubmitFeedbackHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	var requestBody InferenceFeedbackRequest
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if err := requestBody.Validate(); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if fh.S3Client.Client == nil {
+		writeError(c, http.StatusServiceUnavailable, "Feedback storage is not available, S3 is not configured")
+		return
+	}
+	cached, ok := fh.InferenceHandler.Exchanges.Get(requestBody.ResponseID)
+	if !ok || cached.(InferenceExchange).Namespace != namespace {
+		writeError(c, http.StatusNotFound, fmt.Sprintf("Response %s not found, feedback is accepted for %s after a response", requestBody.ResponseID, config.GetInferenceFeedbackWindow()))
+		return
+	}
+
+	record := InferenceFeedbackRecord{
+		Timestamp:         time.Now().UTC(),
+		Thumb:             requestBody.Thumb,
+		Rating:            requestBody.Rating,
+		Correction:        requestBody.Correction,
+		Comment:           requestBody.Comment,
+		InferenceExchange: cached.(InferenceExchange),
+	}
+	// 反馈量小且不可丢失，每条反馈同步写入一个对象，写入成功后才响应
+	data, err := json.Marshal(record)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to encode feedback: %v", err))
+		return
+	}
+	if err := fh.S3Client.PutBytes(c.Request.Context(), config.GetS3Bucket(), feedbackObjectName(namespace, record.Timestamp), append(data, '\n'), "application/x-ndjson"); err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to store feedback: %v", err))
+		return
+	}
+	c.JSON(http.StatusAccepted, record)
+}
+
+// ExportFeedbackHandler exports the feedback of the namespace as JSONL, either as the raw feedback records
+// (format=raw) or as DPO preference pairs (format=dpo, the default). It accepts optional service, from and to
+// (RFC3339) filters.
+func (fh *FeedbackHandler) ExportFeedbackHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	format := c.DefaultQuery("format", "dpo")
+	if format != "dpo" && format != "raw" {
+		writeError(c, http.StatusBadRequest, "'format' must be one of dpo or raw")
+		return
+	}
+	var from, to time.Time
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := c.Query(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid '%s': %v", name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+	if fh.S3Client.Client == nil {
+		writeError(c, http.StatusServiceUnavailable, "Feedback storage is not available, S3 is not configured")
+		return
+	}
+
+	records, err := fh.readFeedback(c.Request.Context(), namespace, func(record InferenceFeedbackRecord) bool {
+		return (c.Query("service") == "" || record.Service == c.Query("service")) &&
+			(from.IsZero() || !record.Timestamp.Before(from)) &&
+			(to.IsZero() || record.Timestamp.Before(to))
+	})
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to read feedback: %v", err))
+		return
+	}
+
+	var lines []interface{}
+	if format == "raw" {
+		for _, record := range records {
+			lines = append(lines, record)
+		}
+	} else {
+		for _, pair := range preferencePairs(records) {
+			lines = append(lines, pair)
+		}
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-feedback-%s.jsonl", namespace, format))
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	for _, line := range lines {
+		if err := encoder.Encode(line); err != nil {
+			return
+		}
+	}
+}
+
+// readFeedback reads the feedback records of the namespace kept by keep, in the order they were given
+func (fh *FeedbackHandler) readFeedback(ctx context.Context, namespace string, keep func(InferenceFeedbackRecord) bool) ([]InferenceFeedbackRecord, error) {
+	bucketName := config.GetS3Bucket()
+	var records []InferenceFeedbackRecord
+	for info := range fh.S3Client.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: feedbackPrefix(namespace) + "/", Recursive: true}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		object, err := fh.S3Client.Client.GetObject(ctx, bucketName, info.Key, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(object)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var record InferenceFeedbackRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				continue
+			}
+			if keep(record) {
+				records = append(records, record)
+			}
+		}
+		err = scanner.Err()
+		object.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", info.Key, err)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+	return records, nil
+}
+
+// preference is the sentiment of a feedback: 1 liked, -1 disliked, 0 neutral
+func (r InferenceFeedbackRecord) preference() int {
+	switch {
+	case r.Thumb == FeedbackThumbUp:
+		return 1
+	case r.Thumb == FeedbackThumbDown:
+		return -1
+	case r.Rating != nil && *r.Rating >= 4:
+		return 1
+	case r.Rating != nil && *r.Rating <= 2:
+		return -1
+	}
+	return 0
+}
+
+// preferencePairs turns feedback records into DPO pairs. Only the latest feedback on a response counts.
+// A correction is preferred over the response it corrects, and for the same conversation every liked
+// response is preferred over every disliked one.
+func preferencePairs(records []InferenceFeedbackRecord) []PreferencePair {
+	latest := make(map[string]InferenceFeedbackRecord)
+	var order []string
+	for _, record := range records {
+		if _, ok := latest[record.ResponseID]; !ok {
+			order = append(order, record.ResponseID)
+		}
+		latest[record.ResponseID] = record
+	}
+
+	type conversation struct {
+		prompt   string
+		history  []InferenceBodyMessage
+		liked    []string
+		disliked []string
+	}
+	conversations := make(map[string]*conversation)
+	var keys []string
+	var pairs []PreferencePair
+	for _, responseID := range order {
+		record := latest[responseID]
+		if len(record.Messages) == 0 {
+			continue
+		}
+		last := len(record.Messages) - 1
+		prompt, history := record.Messages[last].Content, record.Messages[:last]
+		if record.Correction != "" && record.Correction != record.Output {
+			pairs = append(pairs, PreferencePair{Prompt: prompt, Chosen: record.Correction, Rejected: record.Output, History: history})
+			continue
+		}
+		keyData, _ := json.Marshal(record.Messages)
+		key := string(keyData)
+		conv, ok := conversations[key]
+		if !ok {
+			conv = &conversation{prompt: prompt, history: history}
+			conversations[key] = conv
+			keys = append(keys, key)
+		}
+		switch record.preference() {
+		case 1:
+			conv.liked = appendUnique(conv.liked, record.Output)
+		case -1:
+			conv.disliked = appendUnique(conv.disliked, record.Output)
+		}
+	}
+	for _, key := range keys {
+		conv := conversations[key]
+		for _, chosen := range conv.liked {
+			for _, rejected := range conv.disliked {
+				if chosen == rejected {
+					continue
+				}
+				pairs = append(pairs, PreferencePair{Prompt: conv.prompt, Chosen: chosen, Rejected: rejected, History: conv.history})
+			}
+		}
+	}
+	return pairs
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func feedbackRecord(responseID, output, thumb, correction string, at time.Time) InferenceFeedbackRecord {
+	return InferenceFeedbackRecord{
+		Timestamp:  at,
+		Thumb:      thumb,
+		Correction: correction,
+		InferenceExchange: InferenceExchange{
+			ResponseID: responseID,
+			Messages:   []InferenceBodyMessage{{Role: "user", Content: "What is DataTunerX?"}},
+			Output:     output,
+		},
+	}
+}
+
+func TestPreferencePairs(t *testing.T) {
+	now := time.Now()
+	pairs := preferencePairs([]InferenceFeedbackRecord{
+		feedbackRecord("resp-a", "A platform for fine-tuning.", FeedbackThumbUp, "", now),
+		feedbackRecord("resp-b", "A database.", FeedbackThumbUp, "", now),
+		// 以最新一次反馈为准
+		feedbackRecord("resp-b", "A database.", FeedbackThumbDown, "", now.Add(time.Second)),
+		feedbackRecord("resp-c", "No idea.", "", "A cloud native LLM fine-tuning platform.", now),
+	})
+	if len(pairs) != 2 {
+		t.Fatalf("Expected 2 pairs, got %+v", pairs)
+	}
+	if pairs[0].Chosen != "A cloud native LLM fine-tuning platform." || pairs[0].Rejected != "No idea." {
+		t.Errorf("Expected the correction to be chosen, got %+v", pairs[0])
+	}
+	if pairs[1].Prompt != "What is DataTunerX?" || pairs[1].Chosen != "A platform for fine-tuning." || pairs[1].Rejected != "A database." {
+		t.Errorf("Expected the liked response to be chosen over the disliked one, got %+v", pairs[1])
+	}
+}
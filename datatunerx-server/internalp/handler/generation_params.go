@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/DataTunerX/utility-server/logging"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+)
+
+// annotationGenerationDefaults holds the default generation parameters of a rayservice as a JSON object,
+// e.g. {"temperature": 0.2, "max_tokens": 512}
+const annotationGenerationDefaults = "inference.datatunerx.io/generation-defaults"
+
+// maxStopSequences is the most stop sequences accepted per request
+const maxStopSequences = 4
+
+// GenerationParams are the sampling parameters understood by the inference deployment
+type GenerationParams struct {
+	Temperature      *float64      `json:"temperature,omitempty"`
+	TopP             *float64      `json:"top_p,omitempty"`
+	MaxTokens        *int          `json:"max_tokens,omitempty"`
+	Stop             StopSequences `json:"stop,omitempty"`
+	Seed             *int64        `json:"seed,omitempty"`
+	N                *int          `json:"n,omitempty"`
+	PresencePenalty  *float64      `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64      `json:"frequency_penalty,omitempty"`
+}
+
+// StopSequences accepts either a single string or an array of strings
+type StopSequences []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = StopSequences{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = multiple
+	return nil
+}
+
+// Validate checks every set parameter against its allowed range
+func (p GenerationParams) Validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2, got %v", *p.Temperature)
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p must be greater than 0 and at most 1, got %v", *p.TopP)
+	}
+	if p.MaxTokens != nil && *p.MaxTokens < 1 {
+		return fmt.Errorf("max_tokens must be at least 1, got %d", *p.MaxTokens)
+	}
+	if len(p.Stop) > maxStopSequences {
+		return fmt.Errorf("stop accepts at most %d sequences, got %d", maxStopSequences, len(p.Stop))
+	}
+	for i, stop := range p.Stop {
+		if stop == "" {
+			return fmt.Errorf("stop[%d] must be a non-empty string", i)
+		}
+	}
+	if p.N != nil && (*p.N < 1 || *p.N > 16) {
+		return fmt.Errorf("n must be between 1 and 16, got %d", *p.N)
+	}
+	if p.PresencePenalty != nil && (*p.PresencePenalty < -2 || *p.PresencePenalty > 2) {
+		return fmt.Errorf("presence_penalty must be between -2 and 2, got %v", *p.PresencePenalty)
+	}
+	if p.FrequencyPenalty != nil && (*p.FrequencyPenalty < -2 || *p.FrequencyPenalty > 2) {
+		return fmt.Errorf("frequency_penalty must be between -2 and 2, got %v", *p.FrequencyPenalty)
+	}
+	return nil
+}
+
+// WithDefaults fills every unset parameter from defaults
+func (p GenerationParams) WithDefaults(defaults GenerationParams) GenerationParams {
+	if p.Temperature == nil {
+		p.Temperature = defaults.Temperature
+	}
+	if p.TopP == nil {
+		p.TopP = defaults.TopP
+	}
+	if p.MaxTokens == nil {
+		p.MaxTokens = defaults.MaxTokens
+	}
+	if p.Stop == nil {
+		p.Stop = defaults.Stop
+	}
+	if p.Seed == nil {
+		p.Seed = defaults.Seed
+	}
+	if p.N == nil {
+		p.N = defaults.N
+	}
+	if p.PresencePenalty == nil {
+		p.PresencePenalty = defaults.PresencePenalty
+	}
+	if p.FrequencyPenalty == nil {
+		p.FrequencyPenalty = defaults.FrequencyPenalty
+	}
+	return p
+}
+
+// generationDefaults reads the default generation parameters from the rayservice annotation.
+// A missing or invalid annotation yields no defaults.
+func generationDefaults(rayService *rayv1.RayService) GenerationParams {
+	raw, ok := rayService.Annotations[annotationGenerationDefaults]
+	if !ok || raw == "" {
+		return GenerationParams{}
+	}
+	var defaults GenerationParams
+	if err := json.Unmarshal([]byte(raw), &defaults); err != nil {
+		logging.ZLogger.Warnf("Ignoring invalid %s annotation on rayservice %s/%s: %v", annotationGenerationDefaults, rayService.Namespace, rayService.Name, err)
+		return GenerationParams{}
+	}
+	if err := defaults.Validate(); err != nil {
+		logging.ZLogger.Warnf("Ignoring invalid %s annotation on rayservice %s/%s: %v", annotationGenerationDefaults, rayService.Namespace, rayService.Name, err)
+		return GenerationParams{}
+	}
+	return defaults
+}
@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/guardrail"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// guardrailDataKey is the key of the guardrail configuration in the guardrail ConfigMap
+	guardrailDataKey = "guardrails.json"
+	// maxCachedGuardrails bounds the namespaces whose guardrails are cached
+	maxCachedGuardrails = 1024
+)
+
+// GuardrailConfig is the guardrail configuration of a namespace, applied to the request messages
+// and to the model output of every inference in the namespace
+type GuardrailConfig struct {
+	Input     GuardrailStageConfig `json:"input"`
+	Output    GuardrailStageConfig `json:"output"`
+	UpdatedAt time.Time            `json:"updatedAt"`
+}
+
+// GuardrailStageConfig configures the built-in filters of one side, run in the order max length, blocklist, pii
+type GuardrailStageConfig struct {
+	MaxChars        int                 `json:"maxChars,omitempty"`
+	BlockedKeywords []string            `json:"blockedKeywords,omitempty"`
+	BlockedPatterns []string            `json:"blockedPatterns,omitempty"`
+	PII             *GuardrailPIIConfig `json:"pii,omitempty"`
+}
+
+// GuardrailPIIConfig selects the PII kinds to detect, all when empty, and whether to mask or block them
+type GuardrailPIIConfig struct {
+	Kinds  []string `json:"kinds,omitempty"`
+	Action string   `json:"action,omitempty"`
+}
+
+// guardrails are the compiled filter chains of a namespace, a nil *guardrails filters nothing
+type guardrails struct {
+	input  guardrail.Chain
+	output guardrail.Chain
+}
+
+// chain compiles the filters of a side
+func (sc GuardrailStageConfig) chain() (guardrail.Chain, error) {
+	var chain guardrail.Chain
+	if sc.MaxChars < 0 {
+		return nil, fmt.Errorf("'maxChars' must not be negative")
+	}
+	if sc.MaxChars > 0 {
+		chain = append(chain, &guardrail.MaxLength{MaxChars: sc.MaxChars})
+	}
+	if len(sc.BlockedKeywords) > 0 || len(sc.BlockedPatterns) > 0 {
+		blocklist, err := guardrail.NewBlocklist(sc.BlockedKeywords, sc.BlockedPatterns)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, blocklist)
+	}
+	if sc.PII != nil {
+		pii, err := guardrail.NewPIIFilter(sc.PII.Kinds, sc.PII.Action)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, pii)
+	}
+	return chain, nil
+}
+
+// build compiles the configuration, failing if a pattern or option is invalid
+func (gc *GuardrailConfig) build() (*guardrails, error) {
+	input, err := gc.Input.chain()
+	if err != nil {
+		return nil, fmt.Errorf("input: %v", err)
+	}
+	output, err := gc.Output.chain()
+	if err != nil {
+		return nil, fmt.Errorf("output: %v", err)
+	}
+	if len(input) == 0 && len(output) == 0 {
+		return nil, nil
+	}
+	return &guardrails{input: input, output: output}, nil
+}
+
+// filterMessages runs the input filters on the message contents, returning the messages with masked contents
+func (g *guardrails) filterMessages(messages []InferenceBodyMessage) ([]InferenceBodyMessage, error) {
+	if g == nil || len(g.input) == 0 {
+		return messages, nil
+	}
+	texts := make([]string, len(messages))
+	for i, message := range messages {
+		texts[i] = message.Content
+	}
+	texts, err := g.input.Apply(guardrail.Input, texts)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]InferenceBodyMessage, len(messages))
+	for i, message := range messages {
+		filtered[i] = InferenceBodyMessage{Role: message.Role, Content: texts[i]}
+	}
+	return filtered, nil
+}
+
+// filterInput runs the input filters on raw texts such as completion prompts
+func (g *guardrails) filterInput(texts []string) ([]string, error) {
+	if g == nil || len(g.input) == 0 {
+		return texts, nil
+	}
+	return g.input.Apply(guardrail.Input, texts)
+}
+
+// filterOutput runs the output filters on the model output
+func (g *guardrails) filterOutput(output string) (string, error) {
+	if g == nil || len(g.output) == 0 {
+		return output, nil
+	}
+	texts, err := g.output.Apply(guardrail.Output, []string{output})
+	if err != nil {
+		return "", err
+	}
+	return texts[0], nil
+}
+
+// filtersOutput reports whether streamed output must be held back until it can be filtered as a whole
+func (g *guardrails) filtersOutput() bool {
+	return g != nil && len(g.output) > 0
+}
+
+// guardrailsOf returns the compiled guardrails of the namespace, cached for a short time
+func (Ih *InferenceHandler) guardrailsOf(ctx context.Context, namespace string) (*guardrails, error) {
+	if cached, ok := Ih.Guardrails.Get(namespace); ok {
+		return cached.(*guardrails), nil
+	}
+	guardrailConfig, err := Ih.getGuardrailConfig(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	rails, err := guardrailConfig.build()
+	if err != nil {
+		return nil, fmt.Errorf("invalid guardrail configuration in namespace %s: %v", namespace, err)
+	}
+	Ih.Guardrails.Add(namespace, namespace, rails)
+	return rails, nil
+}
+
+// getGuardrailConfig reads the guardrail configuration of the namespace, empty when none is stored
+func (Ih *InferenceHandler) getGuardrailConfig(ctx context.Context, namespace string) (*GuardrailConfig, error) {
+	guardrailConfig := &GuardrailConfig{}
+	configMap, err := Ih.KubeClients.Clientset.CoreV1().ConfigMaps(namespace).Get(ctx, config.GetGuardrailConfigMap(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return guardrailConfig, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := configMap.Data[guardrailDataKey]
+	if !ok {
+		return guardrailConfig, nil
+	}
+	if err := json.Unmarshal([]byte(data), guardrailConfig); err != nil {
+		return nil, fmt.Errorf("invalid guardrail configuration in namespace %s: %v", namespace, err)
+	}
+	return guardrailConfig, nil
+}
+
+// loadGuardrails loads the guardrails of the namespace, writing the error response on failure
+func (Ih *InferenceHandler) loadGuardrails(c *gin.Context, namespace string) (*guardrails, bool) {
+	rails, err := Ih.guardrailsOf(c.Request.Context(), namespace)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to load guardrails: %v", err))
+		return nil, false
+	}
+	return rails, true
+}
+
+// GetGuardrailsHandler returns the guardrail configuration of the namespace
+func (Ih *InferenceHandler) GetGuardrailsHandler(c *gin.Context) {
+	guardrailConfig, err := Ih.getGuardrailConfig(c.Request.Context(), c.Param("namespace"))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to get guardrails: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, guardrailConfig)
+}
+
+// PutGuardrailsHandler replaces the guardrail configuration of the namespace
+func (Ih *InferenceHandler) PutGuardrailsHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	var guardrailConfig GuardrailConfig
+	if err := c.ShouldBindJSON(&guardrailConfig); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if _, err := guardrailConfig.build(); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	guardrailConfig.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(guardrailConfig)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = Ih.updateGuardrailConfigMap(c.Request.Context(), namespace, func(configMapData map[string]string) {
+		configMapData[guardrailDataKey] = string(data)
+	})
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to save guardrails: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, guardrailConfig)
+}
+
+// DeleteGuardrailsHandler removes the guardrail configuration of the namespace
+func (Ih *InferenceHandler) DeleteGuardrailsHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	err := Ih.updateGuardrailConfigMap(c.Request.Context(), namespace, func(configMapData map[string]string) {
+		delete(configMapData, guardrailDataKey)
+	})
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to delete guardrails: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Guardrails of namespace %s deleted", namespace)})
+}
+
+// updateGuardrailConfigMap applies mutate to the data of the guardrail ConfigMap, creating it if needed.
+// The cached guardrails of the namespace are dropped, other replicas pick up the change once their cache expires.
+func (Ih *InferenceHandler) updateGuardrailConfigMap(ctx context.Context, namespace string, mutate func(configMapData map[string]string)) error {
+	defer Ih.Guardrails.RemoveGroup(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMaps := Ih.KubeClients.Clientset.CoreV1().ConfigMaps(namespace)
+		configMap, err := configMaps.Get(ctx, config.GetGuardrailConfigMap(), metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      config.GetGuardrailConfigMap(),
+					Namespace: namespace,
+				},
+			}
+		} else if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		mutate(configMap.Data)
+		if create {
+			_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), configMap.Name, err)
+			}
+			return err
+		}
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
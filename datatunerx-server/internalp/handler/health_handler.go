@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+
+	"datatunerx-server/pkg/ray"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	RayServiceCache *ray.RayServiceCache
+}
+
+// NewHealthHandler creates a new instance of HealthHandler
+func NewHealthHandler(rayServiceCache *ray.RayServiceCache) *HealthHandler {
+	return &HealthHandler{RayServiceCache: rayServiceCache}
+}
+
+// LivenessHandler reports that the server is running
+func (hh *HealthHandler) LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadinessHandler reports ready once the rayservice cache has synced
+func (hh *HealthHandler) ReadinessHandler(c *gin.Context) {
+	if !hh.RayServiceCache.HasSynced() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "reason": "rayservice cache has not synced"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -3,135 +3,400 @@
 package handler
 
 import (
-	"bytes"
 	"context"
 	"datatunerx-server/config"
+	"datatunerx-server/pkg/cache"
 	"datatunerx-server/pkg/k8s"
+	"datatunerx-server/pkg/ratelimit"
 	"datatunerx-server/pkg/ray"
+	"datatunerx-server/pkg/s3"
+	"datatunerx-server/pkg/usage"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"strings"
 
 	"github.com/DataTunerX/utility-server/logging"
 	"github.com/gin-gonic/gin"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type InferenceHandler struct {
-	KubeClients k8s.KubernetesClients
-	RayClients  ray.RayClient
+	KubeClients     k8s.KubernetesClients
+	RayClients      ray.RayClient
+	RayServiceCache *ray.RayServiceCache
+	CaptureWriter   *s3.JSONLWriter
+	Limiters        *ratelimit.Registry
+	Usage           *usage.Tracker
+	ResponseCache   *cache.LRU
+	RouteCounters   *routeCounters
+	Sessions        *chatSessions
+	Guardrails      *cache.LRU
+	Exchanges       *cache.LRU
 }
 
 // NewResourceHandler creates a new instance of ResourceHandler
-func NewInferenceHandler(kubeClients k8s.KubernetesClients, rayClients ray.RayClient) *InferenceHandler {
+func NewInferenceHandler(kubeClients k8s.KubernetesClients, rayClients ray.RayClient, rayServiceCache *ray.RayServiceCache, s3Client s3.S3Client) *InferenceHandler {
+	var captureWriter *s3.JSONLWriter
+	if s3Client.Client != nil {
+		captureWriter = s3.NewJSONLWriter(s3Client, config.GetS3Bucket(), config.GetInferenceCaptureBatchSize(), config.GetInferenceCaptureBufferSize(), config.GetInferenceCaptureFlushInterval())
+	}
+	var usageTracker *usage.Tracker
+	if kubeClients.Clientset != nil {
+		usageStore := usage.NewConfigMapStore(kubeClients.Clientset, config.GetInferenceUsageConfigMap())
+		usageTracker = usage.NewTracker(usageStore, config.GetInferenceUsageFlushInterval(), config.GetInferenceUsageHourlyRetention(), config.GetInferenceUsageDailyRetention(), config.GetInferenceUsageMaxBuckets())
+	}
+	var responseCache *cache.LRU
+	if config.GetInferenceResponseCacheSize() > 0 {
+		responseCache = cache.NewLRU(config.GetInferenceResponseCacheSize(), config.GetInferenceResponseCacheTTL())
+		if rayServiceCache != nil {
+			invalidateResponseCacheOnCheckpointChange(rayServiceCache, responseCache)
+		}
+	}
 	return &InferenceHandler{
-		KubeClients: kubeClients,
-		RayClients:  rayClients,
+		KubeClients:     kubeClients,
+		RayClients:      rayClients,
+		RayServiceCache: rayServiceCache,
+		CaptureWriter:   captureWriter,
+		Limiters:        ratelimit.NewRegistry(),
+		Usage:           usageTracker,
+		ResponseCache:   responseCache,
+		RouteCounters:   newRouteCounters(),
+		Sessions:        newChatSessions(),
+		Guardrails:      cache.NewLRU(maxCachedGuardrails, config.GetGuardrailCacheTTL()),
+		Exchanges:       cache.NewLRU(config.GetInferenceFeedbackMaxPending(), config.GetInferenceFeedbackWindow()),
+	}
+}
+
+// Close flushes the captured exchanges and the recorded usage, it is called on shutdown once no more
+// requests are served
+func (Ih *InferenceHandler) Close(ctx context.Context) {
+	if Ih.CaptureWriter != nil {
+		if err := Ih.CaptureWriter.Close(ctx); err != nil {
+			logging.ZLogger.Errorf("Failed to flush captured inference records: %v", err)
+		}
+	}
+	if Ih.Usage != nil {
+		Ih.Usage.Flush(ctx)
 	}
 }
 
 // InferenceHandler 是处理 /inference 的路由处理函数
 func (Ih *InferenceHandler) InferenceChatHandler(c *gin.Context) {
 	logging.NewZapLogger(config.GetLevel())
-	namespace := c.Param("namespace")
-	rayServiceName := c.Param("serviceName")
+	Ih.chat(c, c.Param("namespace"), c.Param("serviceName"))
+}
+
+// admit resolves the rayservice and checks that it is serving and within its quota and limits.
+// On failure the error response is written and ok is false; otherwise release must be called when done.
+func (Ih *InferenceHandler) admit(c *gin.Context, namespace, rayServiceName string) (rayService *rayv1.RayService, release func(), ok bool) {
+	rayService, release, err := Ih.admitService(c.Request.Context(), namespace, rayServiceName)
+	if err != nil {
+		writeInferenceError(c, err)
+		return nil, nil, false
+	}
+	return rayService, release, true
+}
 
+// admitService is admit for callers writing their own error responses
+func (Ih *InferenceHandler) admitService(ctx context.Context, namespace, rayServiceName string) (*rayv1.RayService, func(), error) {
 	// Fetch rayservice object details
-	rayClient := Ih.RayClients
-	rayserviceObj, err := rayClient.Clientset.RayV1().RayServices(namespace).Get(context.TODO(), rayServiceName, metav1.GetOptions{})
+	rayService, err := Ih.getServeService(ctx, namespace, rayServiceName)
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get rayservice: %v", err)})
-		return
+		return nil, nil, err
 	}
-	serviceName := rayserviceObj.Spec.ServeService.Name
+	// serve 应用未就绪时返回 503 及具体状态
+	if err := checkServeReady(rayService); err != nil {
+		return nil, nil, err
+	}
+	// 命名空间月度 token 配额与 rayservice 级别的限流
+	if err := Ih.checkQuota(ctx, namespace); err != nil {
+		return nil, nil, err
+	}
+	release, err := Ih.acquireLimits(rayService)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rayService, release, nil
+}
 
+// chat serves a chat request against the named rayservice
+func (Ih *InferenceHandler) chat(c *gin.Context, namespace, rayServiceName string) {
 	// 解析请求体
-	var requestBody map[string]interface{}
+	var requestBody InferenceChatRequest
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Failed to parse request body: %v", err)})
+		writeError(c, 400, fmt.Sprintf("Failed to parse request body: %v", err))
 		return
 	}
 
-	// 获取 model 字段值
-	input, ok := requestBody["input"].(string)
-	transferBody := InferenceBody{
-		Model: "serviceName",
-		Messages: []InferenceBodyMessage{
-			{
-				Role:    "user",
-				Content: input,
-			},
-		},
+	// 按名称渲染提示词模板
+	if err := applyPromptTemplate(promptTemplatesOf(c.Request.Context(), Ih.KubeClients, namespace), &requestBody); err != nil {
+		writeError(c, applyPromptTemplateStatus(err), err.Error())
+		return
+	}
+
+	// 组装对话消息：system + messages + input
+	messages, err := buildChatMessages(requestBody)
+	if err != nil {
+		writeError(c, 400, err.Error())
+		return
+	}
+	if err := requestBody.GenerationParams.Validate(); err != nil {
+		writeError(c, 400, fmt.Sprintf("Invalid generation parameter: %v", err))
+		return
+	}
+
+	// 输入护栏：按命名空间配置拦截或脱敏请求消息
+	rails, ok := Ih.loadGuardrails(c, namespace)
+	if !ok {
+		return
+	}
+	if messages, err = rails.filterMessages(messages); err != nil {
+		writeInferenceError(c, err)
+		return
 	}
+
+	// 请求校验通过后再占用配额与限流名额
+	rayserviceObj, release, ok := Ih.admit(c, namespace, rayServiceName)
 	if !ok {
-		c.JSON(400, gin.H{"error": "Missing or invalid 'model' field in the request body"})
 		return
 	}
+	defer release()
+	serviceName := rayserviceObj.Spec.ServeService.Name
+	caller := callerIdentity(c)
+
+	// 生成参数：请求值优先，其余取 rayservice 注解中的默认值
+	transferBody := InferenceBody{
+		Model:            rayServiceName,
+		Messages:         messages,
+		GenerationParams: requestBody.GenerationParams.WithDefaults(generationDefaults(rayserviceObj)),
+	}
 
 	// 构建目标服务地址
-	targetServiceURL := fmt.Sprintf("http://%s.%s.svc.cluster.local/chat/completions", serviceName, namespace)
+	targetServiceURL := serveServiceURL(serviceName, namespace, "/chat/completions")
+
+	// 流式输出：请求体 "stream": true 或 Accept: text/event-stream
+	if requestBody.Stream || strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		responseID := newResponseID()
+		if resp, ok := streamChat(c, targetServiceURL, transferBody, rails, responseID); ok {
+			Ih.recordUsage(rayserviceObj, caller, resp.Usage)
+			Ih.capture(rayserviceObj, transferBody, resp)
+			Ih.rememberExchange(responseID, rayserviceObj, caller, transferBody, resp.Output)
+		}
+		return
+	}
 
-	// 发起转发请求
-	resp, err := forwardRequest(targetServiceURL, transferBody)
+	// 发起转发请求，确定性请求优先读取响应缓存
+	resp, cached, err := Ih.forwardCached(c.Request.Context(), rayserviceObj, targetServiceURL, transferBody, noCacheRequested(c))
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to forward request: %v", err)})
+		writeInferenceError(c, err)
+		return
+	}
+	if cached {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+		Ih.recordUsage(rayserviceObj, caller, resp.Usage)
+	}
+	// 输出护栏，被拦截的输出不采集
+	if resp.Output, err = rails.filterOutput(resp.Output); err != nil {
+		writeInferenceError(c, err)
 		return
 	}
+	Ih.capture(rayserviceObj, transferBody, resp)
+	// 记录本次响应，供提交反馈时引用
+	responseID := newResponseID()
+	Ih.rememberExchange(responseID, rayserviceObj, caller, transferBody, resp.Output)
 
 	// 返回目标服务的响应
 	c.JSON(200, gin.H{
+		"responseId":  responseID,
 		"output":      resp.Output,
 		"tokenLength": resp.TokenLength,
 		"elaspedTime": resp.ElapsedTime,
 		"tokenPerSec": resp.TokenPerSec,
+		"cached":      cached,
 	})
 }
 
+// buildChatMessages assembles the conversation from the optional system prompt, the messages
+// array and the input shorthand, which is appended as the last user turn
+func buildChatMessages(request InferenceChatRequest) ([]InferenceBodyMessage, error) {
+	messages := make([]InferenceBodyMessage, 0, len(request.Messages)+2)
+	if request.System != "" {
+		if len(request.Messages) > 0 && request.Messages[0].Role == "system" {
+			return nil, fmt.Errorf("'system' field conflicts with the system message in 'messages'")
+		}
+		messages = append(messages, InferenceBodyMessage{Role: "system", Content: request.System})
+	}
+	messages = append(messages, request.Messages...)
+	if request.Input != "" {
+		messages = append(messages, InferenceBodyMessage{Role: "user", Content: request.Input})
+	}
+	if err := validateChatMessages(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// validateChatMessages checks roles and ordering: an optional leading system message,
+// then user and assistant turns alternating, starting and ending with user
+func validateChatMessages(messages []InferenceBodyMessage) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("missing 'input' or 'messages' in the request body")
+	}
+	expected := "user"
+	for i, message := range messages {
+		if message.Content == "" {
+			return fmt.Errorf("messages[%d]: content must be a non-empty string", i)
+		}
+		switch message.Role {
+		case "system":
+			if i != 0 {
+				return fmt.Errorf("messages[%d]: system message is only allowed as the first message", i)
+			}
+			continue
+		case "user", "assistant":
+		default:
+			return fmt.Errorf("messages[%d]: invalid role %q, must be one of system, user or assistant", i, message.Role)
+		}
+		if message.Role != expected {
+			return fmt.Errorf("messages[%d]: expected role %q, got %q", i, expected, message.Role)
+		}
+		if expected == "user" {
+			expected = "assistant"
+		} else {
+			expected = "user"
+		}
+	}
+	if messages[len(messages)-1].Role != "user" {
+		return fmt.Errorf("the last message must have role \"user\"")
+	}
+	return nil
+}
+
+// getInferenceService fetches a rayservice and checks that it carries the inference service label
+func (Ih *InferenceHandler) getInferenceService(ctx context.Context, namespace, name string) (*rayv1.RayService, error) {
+	rayService, err := Ih.getServeService(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if !isInferenceService(rayService) {
+		return nil, apierrors.NewNotFound(rayv1.Resource("rayservices"), name)
+	}
+	return rayService, nil
+}
+
+// getServeService fetches a rayservice and checks that it exposes a serve service
+func (Ih *InferenceHandler) getServeService(ctx context.Context, namespace, name string) (*rayv1.RayService, error) {
+	rayService, err := Ih.RayServiceCache.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if rayService.Spec.ServeService == nil {
+		return nil, fmt.Errorf("rayservice %s/%s has no serve service", namespace, name)
+	}
+	return rayService, nil
+}
+
+// isInferenceService reports whether the object matches config.GetInferenceServiceLabel
+func isInferenceService(obj metav1.Object) bool {
+	selector, err := labels.Parse(config.GetInferenceServiceLabel())
+	if err != nil {
+		logging.ZLogger.Errorf("Invalid inference service label %q: %v", config.GetInferenceServiceLabel(), err)
+		return false
+	}
+	return selector.Matches(labels.Set(obj.GetLabels()))
+}
+
+// serveServiceURL 构建目标服务地址
+func serveServiceURL(serviceName, namespace, path string) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local%s", serviceName, namespace, path)
+}
+
 // forwardRequest 发起转发请求
-func forwardRequest(targetURL string, requestBody InferenceBody) (InferenceProcessedResponse, error) {
+func forwardRequest(ctx context.Context, targetURL string, requestBody InferenceBody) (InferenceProcessedResponse, error) {
+	response, err := postInference(ctx, targetURL, requestBody)
+	if err != nil {
+		return InferenceProcessedResponse{}, err
+	}
+	if len(response.Choices) == 0 {
+		return InferenceProcessedResponse{}, &UpstreamError{Message: "response contains no choices"}
+	}
+
+	return processedResponseOf(response), nil
+}
+
+// processedResponseOf 取第一个 choice 构造处理后的响应数据
+func processedResponseOf(response InferenceResponse) InferenceProcessedResponse {
+	return InferenceProcessedResponse{
+		Output:      response.Choices[0].Message.Content,
+		TokenLength: response.Usage.TotalTokens,
+		ElapsedTime: response.Usage.ElapsedTIme,
+		TokenPerSec: response.Usage.TokenPerSec,
+		Usage:       response.Usage,
+	}
+}
+
+// postInference posts a JSON request body upstream and decodes the chat completion response
+func postInference(ctx context.Context, targetURL string, requestBody interface{}) (InferenceResponse, error) {
+	var response InferenceResponse
+	if err := postUpstream(ctx, targetURL, requestBody, &response); err != nil {
+		return InferenceResponse{}, err
+	}
+	return response, nil
+}
+
+// postUpstream posts a JSON request body upstream and decodes the JSON response into response
+func postUpstream(ctx context.Context, targetURL string, requestBody interface{}, response interface{}) error {
 	// 将请求体转换为 JSON 字符串
 	requestBodyBytes, err := json.Marshal(requestBody)
 	if err != nil {
 		logging.ZLogger.Errorf("Failed to marshal JSON request body: %v", err)
-		return InferenceProcessedResponse{}, err
+		return err
 	}
 
 	// 发起 POST 请求
-	resp, err := http.Post(targetURL, "application/json", bytes.NewBuffer(requestBodyBytes))
+	resp, err := defaultUpstream().post(ctx, targetURL, requestBodyBytes, false)
 	if err != nil {
 		logging.ZLogger.Errorf("Failed to forward request: %v", err)
-		return InferenceProcessedResponse{}, err
+		return err
 	}
 	defer resp.Body.Close()
 
+	// 上游返回非 2xx 时携带状态码与响应内容
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newUpstreamStatusError(resp)
+	}
+
 	// 解析响应体
-	var response InferenceResponse
 	decoder := json.NewDecoder(resp.Body)
-	if err := decoder.Decode(&response); err != nil {
+	if err := decoder.Decode(response); err != nil {
 		logging.ZLogger.Errorf("Failed to decode JSON response: %v", err)
-		return InferenceProcessedResponse{}, err
-	}
-
-	// 处理响应数据
-	output := response.Choices[0].Message.Content
-	tokenLength := response.Usage.TotalTokens
-	elapsedTIme := response.Usage.ElapsedTIme
-	tokenPerSec := response.Usage.TokenPerSec
-
-	// 构造处理后的响应数据
-	processedResponse := InferenceProcessedResponse{
-		Output:      output,
-		TokenLength: tokenLength,
-		ElapsedTime: elapsedTIme,
-		TokenPerSec: tokenPerSec,
+		return &UpstreamError{Message: fmt.Sprintf("invalid response body: %v", err)}
 	}
+	return nil
+}
 
-	return processedResponse, nil
+// InferenceChatRequest is the body accepted by InferenceChatHandler
+type InferenceChatRequest struct {
+	Input    string                 `json:"input"`
+	System   string                 `json:"system"`
+	Messages []InferenceBodyMessage `json:"messages"`
+	Stream   bool                   `json:"stream"`
+	// Template names a prompt template of the namespace rendered with Variables into the input
+	Template  string                 `json:"template,omitempty"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	GenerationParams
 }
 
 type InferenceBody struct {
 	Model    string                 `json:"model"`
 	Messages []InferenceBodyMessage `json:"messages"`
+	Stream   bool                   `json:"stream,omitempty"`
+	GenerationParams
 }
 
 type InferenceBodyMessage struct {
@@ -168,4 +433,8 @@ type InferenceProcessedResponse struct {
 	TokenLength string `json:"tokenLength"`
 	ElapsedTime string `json:"elapsedTime"`
 	TokenPerSec string `json:"tokenPerSec"`
+	// ResponseID identifies the response when giving feedback on it
+	ResponseID string `json:"responseId,omitempty"`
+	// Usage is the token usage reported by the deployment, kept for accounting
+	Usage InferenceUsage `json:"-"`
 }
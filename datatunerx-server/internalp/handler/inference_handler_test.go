@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"testing"
+)
+
+func TestBuildChatMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  InferenceChatRequest
+		wantLen  int
+		wantRole string
+		wantErr  bool
+	}{
+		{
+			name:     "input shorthand",
+			request:  InferenceChatRequest{Input: "hello"},
+			wantLen:  1,
+			wantRole: "user",
+		},
+		{
+			name:     "system field with input",
+			request:  InferenceChatRequest{System: "be brief", Input: "hello"},
+			wantLen:  2,
+			wantRole: "system",
+		},
+		{
+			name: "multi-turn messages with trailing input",
+			request: InferenceChatRequest{
+				Messages: []InferenceBodyMessage{
+					{Role: "system", Content: "be brief"},
+					{Role: "user", Content: "hi"},
+					{Role: "assistant", Content: "hello"},
+				},
+				Input: "how are you",
+			},
+			wantLen:  4,
+			wantRole: "system",
+		},
+		{
+			name:    "empty request",
+			request: InferenceChatRequest{},
+			wantErr: true,
+		},
+		{
+			name: "system field conflicts with system message",
+			request: InferenceChatRequest{
+				System:   "be brief",
+				Messages: []InferenceBodyMessage{{Role: "system", Content: "be verbose"}, {Role: "user", Content: "hi"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "consecutive user messages",
+			request: InferenceChatRequest{Messages: []InferenceBodyMessage{{Role: "user", Content: "hi"}, {Role: "user", Content: "hi"}}},
+			wantErr: true,
+		},
+		{
+			name:    "ends with assistant",
+			request: InferenceChatRequest{Messages: []InferenceBodyMessage{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown role",
+			request: InferenceChatRequest{Messages: []InferenceBodyMessage{{Role: "tool", Content: "{}"}}},
+			wantErr: true,
+		},
+		{
+			name:    "empty content",
+			request: InferenceChatRequest{Messages: []InferenceBodyMessage{{Role: "user", Content: ""}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := buildChatMessages(tt.request)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got messages %v", messages)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(messages) != tt.wantLen {
+				t.Errorf("Expected %d messages, got %d", tt.wantLen, len(messages))
+			}
+			if messages[0].Role != tt.wantRole {
+				t.Errorf("Expected first role %q, got %q", tt.wantRole, messages[0].Role)
+			}
+		})
+	}
+}
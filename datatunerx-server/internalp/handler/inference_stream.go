@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// maxStreamLineSize bounds a single upstream SSE line
+const maxStreamLineSize = 1024 * 1024
+
+// streamChat relays the upstream completion to the client as server-sent events.
+// Each chunk is sent as a "message" event and the usage fields as a final "usage" event.
+// When output guardrails are configured the output is held back and sent as a single chunk once filtered.
+// The usage event carries the response ID. It returns the full output once the stream completed.
+func streamChat(c *gin.Context, targetURL string, requestBody InferenceBody, rails *guardrails, responseID string) (InferenceProcessedResponse, bool) {
+	var output strings.Builder
+	started := false
+	startStream := func() {
+		if started {
+			return
+		}
+		started = true
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+	}
+
+	usage, err := forwardStreamRequest(c.Request.Context(), targetURL, requestBody, func(delta string) {
+		if delta == "" {
+			return
+		}
+		output.WriteString(delta)
+		if rails.filtersOutput() {
+			return
+		}
+		startStream()
+		c.SSEvent("message", gin.H{"output": delta})
+		c.Writer.Flush()
+	})
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			logging.ZLogger.Infof("Client closed stream, upstream request cancelled: %v", err)
+			return InferenceProcessedResponse{}, false
+		}
+		if !started {
+			writeInferenceError(c, err)
+			return InferenceProcessedResponse{}, false
+		}
+		c.SSEvent("error", gin.H{"error": fmt.Sprintf("Failed to forward request: %v", err)})
+		c.Writer.Flush()
+		return InferenceProcessedResponse{}, false
+	}
+
+	filtered := output.String()
+	if rails.filtersOutput() {
+		if filtered, err = rails.filterOutput(filtered); err != nil {
+			writeInferenceError(c, err)
+			return InferenceProcessedResponse{}, false
+		}
+		if filtered != "" {
+			startStream()
+			c.SSEvent("message", gin.H{"output": filtered})
+		}
+	}
+
+	resp := InferenceProcessedResponse{
+		Output:      filtered,
+		TokenLength: usage.TotalTokens,
+		ElapsedTime: usage.ElapsedTIme,
+		TokenPerSec: usage.TokenPerSec,
+		Usage:       usage,
+	}
+	startStream()
+	c.SSEvent("usage", InferenceProcessedResponse{
+		TokenLength: resp.TokenLength,
+		ElapsedTime: resp.ElapsedTime,
+		TokenPerSec: resp.TokenPerSec,
+		ResponseID:  responseID,
+	})
+	c.Writer.Flush()
+	return resp, true
+}
+
+// forwardStreamRequest 发起流式转发请求，每收到一段输出调用一次 onDelta
+// Upstreams that do not stream are relayed as a single chunk.
+func forwardStreamRequest(ctx context.Context, targetURL string, requestBody InferenceBody, onDelta func(string)) (InferenceUsage, error) {
+	requestBody.Stream = true
+	return relayStream(ctx, targetURL, requestBody, onDelta)
+}
+
+// relayStream posts any JSON request body upstream and relays the streamed output to onDelta
+func relayStream(ctx context.Context, targetURL string, requestBody interface{}, onDelta func(string)) (InferenceUsage, error) {
+	requestBodyBytes, err := json.Marshal(requestBody)
+	if err != nil {
+		logging.ZLogger.Errorf("Failed to marshal JSON request body: %v", err)
+		return InferenceUsage{}, err
+	}
+
+	// 请求绑定客户端上下文，客户端断开时取消上游请求
+	resp, err := defaultUpstream().post(ctx, targetURL, requestBodyBytes, true)
+	if err != nil {
+		logging.ZLogger.Errorf("Failed to forward stream request: %v", err)
+		return InferenceUsage{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return InferenceUsage{}, newUpstreamStatusError(resp)
+	}
+
+	// 上游不支持流式输出时，整体作为一段返回
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var response InferenceResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			logging.ZLogger.Errorf("Failed to decode JSON response: %v", err)
+			return InferenceUsage{}, &UpstreamError{Message: fmt.Sprintf("invalid response body: %v", err)}
+		}
+		if len(response.Choices) == 0 {
+			return InferenceUsage{}, &UpstreamError{Message: "response contains no choices"}
+		}
+		onDelta(response.Choices[0].Message.Content)
+		return response.Usage, nil
+	}
+
+	var usage InferenceUsage
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		var chunk InferenceStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			logging.ZLogger.Warnf("Skipping malformed stream chunk: %v", err)
+			continue
+		}
+		for _, choice := range chunk.Choices {
+			onDelta(choice.Delta.Content)
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return usage, err
+	}
+	return usage, ctx.Err()
+}
+
+type InferenceStreamChoice struct {
+	Index        int                  `json:"index"`
+	Delta        InferenceBodyMessage `json:"delta"`
+	FinishReason string               `json:"finish_reason"`
+}
+
+type InferenceStreamChunk struct {
+	ID      string                  `json:"id"`
+	Object  string                  `json:"object"`
+	Created int64                   `json:"created"`
+	Model   string                  `json:"model"`
+	Choices []InferenceStreamChoice `json:"choices"`
+	Usage   *InferenceUsage         `json:"usage,omitempty"`
+}
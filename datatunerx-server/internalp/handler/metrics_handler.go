@@ -18,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"datatunerx-server/pkg/k8s"
+	"datatunerx-server/pkg/metrics"
 )
 
 type FinetuneMetricsHandler struct {
@@ -56,7 +57,7 @@ func (h *FinetuneMetricsHandler) GetFinetuneMetrics(c *gin.Context) {
 	fmt.Printf("Received request: namespace=%s, finetuneNames=%s\n", namespace, finetuneNames)
 
 	if len(finetuneNames) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing query parameter 'finetune_name'"})
+		writeError(c, http.StatusBadRequest, "Missing query parameter 'finetune_name'")
 		return
 	}
 	// Convert finetuneNames to a map for efficient lookup
@@ -78,7 +79,7 @@ func (h *FinetuneMetricsHandler) GetFinetuneMetrics(c *gin.Context) {
 
 	finetuneInstances, err := dynamicClient.Resource(resourceGroupVersion).Namespace(namespace).List(c, metav1.ListOptions{})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to get finetuneInstances: %v", err)})
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to get finetuneInstances: %v", err))
 		return
 	}
 
@@ -100,7 +101,7 @@ func (h *FinetuneMetricsHandler) GetFinetuneMetrics(c *gin.Context) {
 
 	prometheusClient, err := newPrometheusClient()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -115,10 +116,12 @@ func (h *FinetuneMetricsHandler) GetFinetuneMetrics(c *gin.Context) {
 
 	fmt.Printf("query: %s\n", query)
 
+	queryStart := time.Now()
 	val, _, err := prometheusClient.QueryRange(c, query, r)
+	metrics.ObservePrometheusQuery(time.Since(queryStart), err)
 	if err != nil {
 		fmt.Printf("Error querying Prometheus: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -0,0 +1,354 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/guardrail"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"github.com/gin-gonic/gin"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+)
+
+// OpenAIChatCompletionsHandler serves POST /v1/chat/completions, routing by the model field
+// to the rayservice of the same name in the namespace
+func (Ih *InferenceHandler) OpenAIChatCompletionsHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+
+	// 消息列表与生成参数与原生接口一致，校验后转发
+	var requestBody InferenceBody
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "", fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	model := requestBody.Model
+	if model == "" {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "Missing or invalid 'model' field in the request body")
+		return
+	}
+	if len(requestBody.Messages) == 0 {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "Missing or invalid 'messages' field in the request body")
+		return
+	}
+	if err := requestBody.GenerationParams.Validate(); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "", fmt.Sprintf("Invalid generation parameter: %v", err))
+		return
+	}
+
+	// 输入护栏：按命名空间配置拦截或脱敏请求消息
+	rails, err := Ih.guardrailsOf(c.Request.Context(), namespace)
+	if err != nil {
+		openAIError(c, http.StatusInternalServerError, "server_error", "", fmt.Sprintf("Failed to load guardrails: %v", err))
+		return
+	}
+	if requestBody.Messages, err = rails.filterMessages(requestBody.Messages); err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+
+	// model 只能指向带推理服务标签的 rayservice
+	if _, err := Ih.getInferenceService(c.Request.Context(), namespace, model); err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+	rayService, release, err := Ih.admitService(c.Request.Context(), namespace, model)
+	if err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+	defer release()
+	requestBody.GenerationParams = requestBody.GenerationParams.WithDefaults(generationDefaults(rayService))
+	targetServiceURL := serveServiceURL(rayService.Spec.ServeService.Name, namespace, "/chat/completions")
+
+	caller := callerIdentity(c)
+
+	if requestBody.Stream {
+		if resp, ok := streamOpenAIChat(c, targetServiceURL, model, requestBody, rails); ok {
+			Ih.recordUsage(rayService, caller, resp.Usage)
+			Ih.capture(rayService, requestBody, resp)
+		}
+		return
+	}
+
+	response, cached, err := Ih.forwardOpenAIChat(c.Request.Context(), rayService, targetServiceURL, requestBody, noCacheRequested(c))
+	if err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+	if cached {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+		Ih.recordUsage(rayService, caller, response.Usage)
+	}
+	for i := range response.Choices {
+		if response.Choices[i].Message.Content, err = rails.filterOutput(response.Choices[i].Message.Content); err != nil {
+			writeOpenAIError(c, err)
+			return
+		}
+	}
+	Ih.capture(rayService, requestBody, processedResponseOf(response))
+	if response.Created == 0 {
+		response.Created = time.Now().Unix()
+	}
+	c.JSON(http.StatusOK, OpenAIChatCompletionResponse{
+		ID:                response.ID,
+		Object:            "chat.completion",
+		Created:           response.Created,
+		Model:             model,
+		SystemFingerprint: response.SystemFingerprint,
+		Choices:           response.Choices,
+		Usage:             toOpenAIUsage(response.Usage),
+	})
+}
+
+// forwardOpenAIChat forwards the request, serving single-choice deterministic requests from the response
+// cache like forwardCached; requests for several choices are forwarded as is
+func (Ih *InferenceHandler) forwardOpenAIChat(ctx context.Context, rayService *rayv1.RayService, targetURL string, requestBody InferenceBody, noCache bool) (InferenceResponse, bool, error) {
+	if requestBody.N != nil && *requestBody.N > 1 {
+		response, err := postInference(ctx, targetURL, requestBody)
+		if err == nil && len(response.Choices) == 0 {
+			err = &UpstreamError{Message: "response contains no choices"}
+		}
+		return response, false, err
+	}
+	resp, cached, err := Ih.forwardCached(ctx, rayService, targetURL, requestBody, noCache)
+	if err != nil {
+		return InferenceResponse{}, false, err
+	}
+	return InferenceResponse{
+		ID:      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		Choices: []InferenceChoice{{Index: 0, Message: InferenceBodyMessage{Role: "assistant", Content: resp.Output}, FinishReason: "stop"}},
+		Usage:   resp.Usage,
+	}, cached, nil
+}
+
+// OpenAIListModelsHandler serves GET /v1/models, listing the inference rayservices in the namespace
+func (Ih *InferenceHandler) OpenAIListModelsHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	rayServicesList, err := Ih.RayServiceCache.List(c.Request.Context(), namespace, config.GetInferenceServiceLabel())
+	if err != nil {
+		openAIError(c, http.StatusInternalServerError, "server_error", "", fmt.Sprintf("Failed to list rayservices: %v", err))
+		return
+	}
+
+	models := OpenAIModelList{Object: "list", Data: make([]OpenAIModel, 0, len(rayServicesList.Items))}
+	for _, rayService := range rayServicesList.Items {
+		models.Data = append(models.Data, OpenAIModel{
+			ID:      rayService.Name,
+			Object:  "model",
+			Created: rayService.CreationTimestamp.Unix(),
+			OwnedBy: namespace,
+		})
+	}
+	c.JSON(http.StatusOK, models)
+}
+
+// streamOpenAIChat relays the upstream completion as OpenAI chat.completion.chunk events,
+// returning the output and usage once the stream completed. With output guardrails the content is sent as one chunk once filtered.
+func streamOpenAIChat(c *gin.Context, targetURL, model string, requestBody InferenceBody, rails *guardrails) (InferenceProcessedResponse, bool) {
+	id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+	started := false
+	writeChunk := func(chunk OpenAIChatCompletionChunk) {
+		if !started {
+			started = true
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			c.Header("X-Accel-Buffering", "no")
+			c.Status(http.StatusOK)
+		}
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			logging.ZLogger.Errorf("Failed to marshal stream chunk: %v", err)
+			return
+		}
+		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+		c.Writer.Flush()
+	}
+	newChunk := func(delta OpenAIChunkDelta, finishReason *string) OpenAIChatCompletionChunk {
+		return OpenAIChatCompletionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []OpenAIChunkChoice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+		}
+	}
+
+	sentRole := false
+	var output strings.Builder
+	usage, err := forwardStreamRequest(c.Request.Context(), targetURL, requestBody, func(delta string) {
+		if delta == "" {
+			return
+		}
+		output.WriteString(delta)
+		if rails.filtersOutput() {
+			return
+		}
+		message := OpenAIChunkDelta{Content: delta}
+		if !sentRole {
+			message.Role = "assistant"
+			sentRole = true
+		}
+		writeChunk(newChunk(message, nil))
+	})
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			logging.ZLogger.Infof("Client closed stream, upstream request cancelled: %v", err)
+			return InferenceProcessedResponse{}, false
+		}
+		if !started {
+			writeOpenAIError(c, err)
+			return InferenceProcessedResponse{}, false
+		}
+		logging.ZLogger.Errorf("Upstream stream failed: %v", err)
+		return InferenceProcessedResponse{}, false
+	}
+
+	filtered := output.String()
+	if rails.filtersOutput() {
+		if filtered, err = rails.filterOutput(filtered); err != nil {
+			writeOpenAIError(c, err)
+			return InferenceProcessedResponse{}, false
+		}
+		if filtered != "" {
+			writeChunk(newChunk(OpenAIChunkDelta{Role: "assistant", Content: filtered}, nil))
+		}
+	}
+
+	stop := "stop"
+	final := newChunk(OpenAIChunkDelta{}, &stop)
+	openAIUsage := toOpenAIUsage(usage)
+	final.Usage = &openAIUsage
+	writeChunk(final)
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+	return InferenceProcessedResponse{
+		Output:      filtered,
+		TokenLength: usage.TotalTokens,
+		ElapsedTime: usage.ElapsedTIme,
+		TokenPerSec: usage.TokenPerSec,
+		Usage:       usage,
+	}, true
+}
+
+// toOpenAIUsage converts the string token counts reported by the deployment into integers
+func toOpenAIUsage(usage InferenceUsage) OpenAIUsage {
+	atoi := func(s string) int {
+		val, err := strconv.Atoi(s)
+		if err != nil {
+			val = 0
+		}
+		return val
+	}
+	return OpenAIUsage{
+		PromptTokens:     atoi(usage.PromptTokens),
+		CompletionTokens: atoi(usage.CompletionTokens),
+		TotalTokens:      atoi(usage.TotalTokens),
+	}
+}
+
+// writeOpenAIError writes an inference error in the OpenAI API format, with the status and message
+// the native endpoints respond with
+func writeOpenAIError(c *gin.Context, err error) {
+	setRetryAfter(c, err)
+	status, body := inferenceErrorResponse(err)
+	message := fmt.Sprint(body["message"])
+	var violation *guardrail.Violation
+	var quotaExceeded *QuotaExceededError
+	switch {
+	case errors.As(err, &violation):
+		openAIError(c, status, "invalid_request_error", "content_filter", message)
+	case errors.As(err, &quotaExceeded):
+		openAIError(c, status, "insufficient_quota", "insufficient_quota", message)
+	case status == http.StatusNotFound:
+		openAIError(c, status, "invalid_request_error", "model_not_found", message)
+	case status == http.StatusTooManyRequests:
+		openAIError(c, status, "requests", "rate_limit_exceeded", message)
+	case status == http.StatusServiceUnavailable:
+		openAIError(c, status, "server_error", "service_unavailable", message)
+	case status < http.StatusInternalServerError:
+		openAIError(c, status, "invalid_request_error", "", message)
+	default:
+		openAIError(c, status, "server_error", "", message)
+	}
+}
+
+// openAIError writes an error body in the OpenAI API format
+func openAIError(c *gin.Context, status int, errType, code, message string) {
+	c.JSON(status, OpenAIErrorResponse{
+		Error: OpenAIErrorBody{
+			Message: message,
+			Type:    errType,
+			Code:    code,
+		},
+	})
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type OpenAIChatCompletionResponse struct {
+	ID                string            `json:"id"`
+	Object            string            `json:"object"`
+	Created           int64             `json:"created"`
+	Model             string            `json:"model"`
+	SystemFingerprint string            `json:"system_fingerprint,omitempty"`
+	Choices           []InferenceChoice `json:"choices"`
+	Usage             OpenAIUsage       `json:"usage"`
+}
+
+type OpenAIChunkDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type OpenAIChunkChoice struct {
+	Index        int              `json:"index"`
+	Delta        OpenAIChunkDelta `json:"delta"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type OpenAIChatCompletionChunk struct {
+	ID      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Model   string              `json:"model"`
+	Choices []OpenAIChunkChoice `json:"choices"`
+	Usage   *OpenAIUsage        `json:"usage,omitempty"`
+}
+
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type OpenAIModelList struct {
+	Object string        `json:"object"`
+	Data   []OpenAIModel `json:"data"`
+}
+
+type OpenAIErrorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+type OpenAIErrorResponse struct {
+	Error OpenAIErrorBody `json:"error"`
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestWriteOpenAIError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		err     error
+		status  int
+		errType string
+		code    string
+	}{
+		{apierrors.NewNotFound(rayv1.Resource("rayservices"), "llama"), http.StatusNotFound, "invalid_request_error", "model_not_found"},
+		{&QuotaExceededError{Namespace: "default", Quota: 10, Used: 12, ResetAt: time.Now().Add(time.Hour)}, http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota"},
+		{&RateLimitedError{RetryAfter: 1}, http.StatusTooManyRequests, "requests", "rate_limit_exceeded"},
+		{&CircuitOpenError{Upstream: "llama", RetryAfter: 1}, http.StatusServiceUnavailable, "server_error", "service_unavailable"},
+	}
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		writeOpenAIError(c, tc.err)
+
+		var body OpenAIErrorResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode error body: %v", err)
+		}
+		if recorder.Code != tc.status || body.Error.Type != tc.errType || body.Error.Code != tc.code {
+			t.Errorf("Expected %d %s/%s for %v, got %d %s/%s", tc.status, tc.errType, tc.code, tc.err, recorder.Code, body.Error.Type, body.Error.Code)
+		}
+		if body.Error.Message == "" {
+			t.Errorf("Expected a message for %v", tc.err)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/k8s"
+
+	"github.com/DataTunerX/utility-server/parser"
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+// templateVariablePattern matches the variables a prompt template prints, e.g. {{ .question }}.
+// Variables only used in conditions such as {{ if .context }} are optional.
+var templateVariablePattern = regexp.MustCompile(`{{-?\s*\.([A-Za-z_][A-Za-z0-9_]*)`)
+
+// PromptTemplate is an instruction wrapper stored under its name in the prompt template ConfigMap
+// of a namespace. System and Prompt are Go templates rendered against the request variables.
+type PromptTemplate struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	System      string    `json:"system,omitempty"`
+	Prompt      string    `json:"prompt"`
+	Variables   []string  `json:"variables"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// PromptTemplatePreviewRequest is the body accepted by PreviewPromptTemplateHandler
+type PromptTemplatePreviewRequest struct {
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// PromptTemplateNotFoundError is returned when a chat request names a template that does not exist
+type PromptTemplateNotFoundError struct {
+	Namespace string
+	Name      string
+}
+
+func (e *PromptTemplateNotFoundError) Error() string {
+	return fmt.Sprintf("prompt template %s not found in namespace %s", e.Name, e.Namespace)
+}
+
+// PromptTemplateHandler manages the prompt templates of a namespace
+type PromptTemplateHandler struct {
+	KubeClients k8s.KubernetesClients
+}
+
+// NewPromptTemplateHandler creates a new instance of PromptTemplateHandler
+func NewPromptTemplateHandler(kubeClients k8s.KubernetesClients) *PromptTemplateHandler {
+	return &PromptTemplateHandler{KubeClients: kubeClients}
+}
+
+// ListPromptTemplatesHandler lists the prompt templates of the namespace sorted by name
+func (ph *PromptTemplateHandler) ListPromptTemplatesHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	templates, err := listPromptTemplates(c.Request.Context(), ph.KubeClients, namespace)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to list prompt templates: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+// GetPromptTemplateHandler returns one prompt template
+func (ph *PromptTemplateHandler) GetPromptTemplateHandler(c *gin.Context) {
+	promptTemplate, err := getPromptTemplate(c.Request.Context(), ph.KubeClients, c.Param("namespace"), c.Param("templateName"))
+	if err != nil {
+		writeError(c, promptTemplateErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, promptTemplate)
+}
+
+// CreatePromptTemplateHandler creates a prompt template, failing if the name is taken
+func (ph *PromptTemplateHandler) CreatePromptTemplateHandler(c *gin.Context) {
+	var promptTemplate PromptTemplate
+	if err := c.ShouldBindJSON(&promptTemplate); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	ph.savePromptTemplate(c, promptTemplate, false)
+}
+
+// UpdatePromptTemplateHandler creates or replaces the prompt template named in the path
+func (ph *PromptTemplateHandler) UpdatePromptTemplateHandler(c *gin.Context) {
+	var promptTemplate PromptTemplate
+	if err := c.ShouldBindJSON(&promptTemplate); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if promptTemplate.Name != "" && promptTemplate.Name != c.Param("templateName") {
+		writeError(c, http.StatusBadRequest, "'name' does not match the template name in the path")
+		return
+	}
+	promptTemplate.Name = c.Param("templateName")
+	ph.savePromptTemplate(c, promptTemplate, true)
+}
+
+// DeletePromptTemplateHandler deletes a prompt template
+func (ph *PromptTemplateHandler) DeletePromptTemplateHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("templateName")
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMaps := ph.KubeClients.Clientset.CoreV1().ConfigMaps(namespace)
+		configMap, err := configMaps.Get(c.Request.Context(), config.GetPromptTemplateConfigMap(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return &PromptTemplateNotFoundError{Namespace: namespace, Name: name}
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := configMap.Data[name]; !ok {
+			return &PromptTemplateNotFoundError{Namespace: namespace, Name: name}
+		}
+		delete(configMap.Data, name)
+		_, err = configMaps.Update(c.Request.Context(), configMap, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		writeError(c, promptTemplateErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Prompt template %s deleted", name)})
+}
+
+// PreviewPromptTemplateHandler renders a prompt template against the variables without calling a model
+func (ph *PromptTemplateHandler) PreviewPromptTemplateHandler(c *gin.Context) {
+	var requestBody PromptTemplatePreviewRequest
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	promptTemplate, err := getPromptTemplate(c.Request.Context(), ph.KubeClients, c.Param("namespace"), c.Param("templateName"))
+	if err != nil {
+		writeError(c, promptTemplateErrorStatus(err), err.Error())
+		return
+	}
+	system, prompt, err := promptTemplate.Render(requestBody.Variables)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	messages := make([]InferenceBodyMessage, 0, 2)
+	if system != "" {
+		messages = append(messages, InferenceBodyMessage{Role: "system", Content: system})
+	}
+	messages = append(messages, InferenceBodyMessage{Role: "user", Content: prompt})
+	c.JSON(http.StatusOK, gin.H{
+		"system":   system,
+		"prompt":   prompt,
+		"messages": messages,
+	})
+}
+
+// savePromptTemplate validates the template and writes it, replacing an existing one only if replace is set
+func (ph *PromptTemplateHandler) savePromptTemplate(c *gin.Context, promptTemplate PromptTemplate, replace bool) {
+	namespace := c.Param("namespace")
+	if err := promptTemplate.Validate(); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	promptTemplate.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(promptTemplate)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMaps := ph.KubeClients.Clientset.CoreV1().ConfigMaps(namespace)
+		configMap, err := configMaps.Get(c.Request.Context(), config.GetPromptTemplateConfigMap(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			status = http.StatusCreated
+			_, err = configMaps.Create(c.Request.Context(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      config.GetPromptTemplateConfigMap(),
+					Namespace: namespace,
+				},
+				Data: map[string]string{promptTemplate.Name: string(data)},
+			}, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), config.GetPromptTemplateConfigMap(), err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if _, exists := configMap.Data[promptTemplate.Name]; exists {
+			if !replace {
+				return apierrors.NewAlreadyExists(corev1.Resource("prompttemplates"), promptTemplate.Name)
+			}
+			status = http.StatusOK
+		} else {
+			status = http.StatusCreated
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[promptTemplate.Name] = string(data)
+		_, err = configMaps.Update(c.Request.Context(), configMap, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		writeError(c, promptTemplateErrorStatus(err), fmt.Sprintf("Failed to save prompt template: %v", err))
+		return
+	}
+	c.JSON(status, promptTemplate)
+}
+
+// Validate checks the name and that both templates parse, and fills in the referenced variables
+func (pt *PromptTemplate) Validate() error {
+	if pt.Name == "" {
+		return fmt.Errorf("missing 'name' in the request body")
+	}
+	if errs := validation.IsConfigMapKey(pt.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid template name %q: %s", pt.Name, strings.Join(errs, "; "))
+	}
+	if strings.TrimSpace(pt.Prompt) == "" {
+		return fmt.Errorf("missing 'prompt' in the request body")
+	}
+	if _, err := template.New("system").Parse(pt.System); err != nil {
+		return fmt.Errorf("invalid 'system' template: %v", err)
+	}
+	if _, err := template.New("prompt").Parse(pt.Prompt); err != nil {
+		return fmt.Errorf("invalid 'prompt' template: %v", err)
+	}
+	pt.Variables = templateVariables(pt.System + "\n" + pt.Prompt)
+	return nil
+}
+
+// Render renders the system and prompt templates, every printed variable must be provided
+func (pt *PromptTemplate) Render(variables map[string]interface{}) (string, string, error) {
+	var missing []string
+	for _, name := range templateVariables(pt.System + "\n" + pt.Prompt) {
+		if _, ok := variables[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", "", fmt.Errorf("missing variables for prompt template %s: %s", pt.Name, strings.Join(missing, ", "))
+	}
+	system, err := parser.ReplaceTemplate(pt.System, variables)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render system template: %v", err)
+	}
+	prompt, err := parser.ReplaceTemplate(pt.Prompt, variables)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render prompt template: %v", err)
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return "", "", fmt.Errorf("prompt template %s rendered an empty prompt", pt.Name)
+	}
+	return system, prompt, nil
+}
+
+// templateVariables returns the sorted, distinct variables printed by the template text
+func templateVariables(text string) []string {
+	seen := make(map[string]struct{})
+	variables := []string{}
+	for _, match := range templateVariablePattern.FindAllStringSubmatch(text, -1) {
+		if _, ok := seen[match[1]]; ok {
+			continue
+		}
+		seen[match[1]] = struct{}{}
+		variables = append(variables, match[1])
+	}
+	sort.Strings(variables)
+	return variables
+}
+
+// getPromptTemplate reads one template from the prompt template ConfigMap of the namespace
+func getPromptTemplate(ctx context.Context, kubeClients k8s.KubernetesClients, namespace, name string) (*PromptTemplate, error) {
+	configMap, err := kubeClients.Clientset.CoreV1().ConfigMaps(namespace).Get(ctx, config.GetPromptTemplateConfigMap(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, &PromptTemplateNotFoundError{Namespace: namespace, Name: name}
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := configMap.Data[name]
+	if !ok {
+		return nil, &PromptTemplateNotFoundError{Namespace: namespace, Name: name}
+	}
+	return decodePromptTemplate(name, data)
+}
+
+// listPromptTemplates reads every template of the namespace, skipping entries that do not decode
+func listPromptTemplates(ctx context.Context, kubeClients k8s.KubernetesClients, namespace string) ([]PromptTemplate, error) {
+	templates := []PromptTemplate{}
+	configMap, err := kubeClients.Clientset.CoreV1().ConfigMaps(namespace).Get(ctx, config.GetPromptTemplateConfigMap(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return templates, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for name, data := range configMap.Data {
+		promptTemplate, err := decodePromptTemplate(name, data)
+		if err != nil {
+			continue
+		}
+		templates = append(templates, *promptTemplate)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, nil
+}
+
+func decodePromptTemplate(name, data string) (*PromptTemplate, error) {
+	var promptTemplate PromptTemplate
+	if err := json.Unmarshal([]byte(data), &promptTemplate); err != nil {
+		return nil, fmt.Errorf("invalid prompt template %s: %v", name, err)
+	}
+	promptTemplate.Name = name
+	promptTemplate.Variables = templateVariables(promptTemplate.System + "\n" + promptTemplate.Prompt)
+	return &promptTemplate, nil
+}
+
+// promptTemplateLookup returns the prompt template of the given name
+type promptTemplateLookup func(name string) (*PromptTemplate, error)
+
+// promptTemplatesOf looks templates up in the namespace on every call
+func promptTemplatesOf(ctx context.Context, kubeClients k8s.KubernetesClients, namespace string) promptTemplateLookup {
+	return func(name string) (*PromptTemplate, error) {
+		return getPromptTemplate(ctx, kubeClients, namespace, name)
+	}
+}
+
+// applyPromptTemplate renders the template named by the request into its system prompt and input
+func applyPromptTemplate(lookup promptTemplateLookup, request *InferenceChatRequest) error {
+	if request.Template == "" {
+		if len(request.Variables) > 0 {
+			return fmt.Errorf("'variables' requires 'template'")
+		}
+		return nil
+	}
+	if request.Input != "" {
+		return fmt.Errorf("'input' conflicts with 'template', pass the input as a template variable")
+	}
+	promptTemplate, err := lookup(request.Template)
+	if err != nil {
+		return err
+	}
+	system, prompt, err := promptTemplate.Render(request.Variables)
+	if err != nil {
+		return err
+	}
+	// 请求中显式给出的 system 优先于模板
+	if request.System == "" {
+		request.System = system
+	}
+	request.Input = prompt
+	request.Template = ""
+	request.Variables = nil
+	return nil
+}
+
+// promptTemplateErrorStatus maps prompt template errors to a status code
+func promptTemplateErrorStatus(err error) int {
+	var notFound *PromptTemplateNotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case apierrors.IsAlreadyExists(err):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// applyPromptTemplateStatus maps errors of applyPromptTemplate to a status code, anything
+// not coming from the API server is a problem with the request
+func applyPromptTemplateStatus(err error) int {
+	var notFound *PromptTemplateNotFoundError
+	var apiStatus apierrors.APIStatus
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &apiStatus):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
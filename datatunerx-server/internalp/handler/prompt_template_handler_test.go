@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyPromptTemplate(t *testing.T) {
+	templates := map[string]*PromptTemplate{
+		"summarize": {
+			Name:   "summarize",
+			System: "You are a {{ .tone }} assistant.",
+			Prompt: "Summarize the following text:\n{{ .text }}",
+		},
+	}
+	lookup := func(name string) (*PromptTemplate, error) {
+		if promptTemplate, ok := templates[name]; ok {
+			return promptTemplate, nil
+		}
+		return nil, &PromptTemplateNotFoundError{Namespace: "default", Name: name}
+	}
+
+	request := InferenceChatRequest{
+		Template:  "summarize",
+		Variables: map[string]interface{}{"tone": "concise", "text": "Ray Serve scales models."},
+	}
+	if err := applyPromptTemplate(lookup, &request); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if request.System != "You are a concise assistant." || request.Input != "Summarize the following text:\nRay Serve scales models." {
+		t.Errorf("Unexpected rendered request: %+v", request)
+	}
+
+	request = InferenceChatRequest{Template: "summarize", Variables: map[string]interface{}{"tone": "concise"}}
+	if err := applyPromptTemplate(lookup, &request); err == nil || applyPromptTemplateStatus(err) != 400 {
+		t.Errorf("Expected a 400 error for a missing variable, got %v", err)
+	}
+
+	request = InferenceChatRequest{Template: "translate"}
+	if err := applyPromptTemplate(lookup, &request); err == nil || applyPromptTemplateStatus(err) != 404 {
+		t.Errorf("Expected a 404 error for an unknown template, got %v", err)
+	}
+
+	request = InferenceChatRequest{Template: "summarize", Input: "hello"}
+	if err := applyPromptTemplate(lookup, &request); err == nil {
+		t.Error("Expected 'input' to conflict with 'template'")
+	}
+}
+
+func TestTemplateVariables(t *testing.T) {
+	// 条件中引用的变量是可选的
+	variables := templateVariables("{{ .b }} {{- .a }} {{ .b }} {{ if .c }}x{{ end }}")
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(variables, expected) {
+		t.Errorf("Expected %v, got %v", expected, variables)
+	}
+}
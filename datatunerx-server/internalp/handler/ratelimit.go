@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/ratelimit"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"github.com/gin-gonic/gin"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+)
+
+// Annotations overriding the configured per-rayservice limits
+const (
+	annotationRateLimitRPS   = "inference.datatunerx.io/rate-limit-rps"
+	annotationRateLimitBurst = "inference.datatunerx.io/rate-limit-burst"
+	annotationMaxInFlight    = "inference.datatunerx.io/max-in-flight"
+)
+
+// RateLimitedError is returned when a namespace or rayservice limit rejects a request
+type RateLimitedError struct {
+	Scope      string
+	RetryAfter int
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("too many requests for %s, retry after %ds", e.Scope, e.RetryAfter)
+}
+
+// limitKeys returns the namespace and rayservice limiter keys for the rayservice
+func limitKeys(rayService *rayv1.RayService) []ratelimit.Key {
+	serviceLimits := ratelimit.Limits{
+		RPS:         config.GetInferenceServiceRateLimitRPS(),
+		Burst:       config.GetInferenceServiceRateLimitBurst(),
+		MaxInFlight: config.GetInferenceServiceMaxInFlight(),
+	}
+	annotations := rayService.Annotations
+	if value, ok := annotations[annotationRateLimitRPS]; ok {
+		if rps, err := strconv.ParseFloat(value, 64); err == nil {
+			serviceLimits.RPS = rps
+		} else {
+			logging.ZLogger.Warnf("Ignoring invalid %s annotation on rayservice %s/%s: %v", annotationRateLimitRPS, rayService.Namespace, rayService.Name, err)
+		}
+	}
+	if value, ok := annotations[annotationRateLimitBurst]; ok {
+		if burst, err := strconv.Atoi(value); err == nil {
+			serviceLimits.Burst = burst
+		} else {
+			logging.ZLogger.Warnf("Ignoring invalid %s annotation on rayservice %s/%s: %v", annotationRateLimitBurst, rayService.Namespace, rayService.Name, err)
+		}
+	}
+	if value, ok := annotations[annotationMaxInFlight]; ok {
+		if maxInFlight, err := strconv.Atoi(value); err == nil {
+			serviceLimits.MaxInFlight = maxInFlight
+		} else {
+			logging.ZLogger.Warnf("Ignoring invalid %s annotation on rayservice %s/%s: %v", annotationMaxInFlight, rayService.Namespace, rayService.Name, err)
+		}
+	}
+
+	return []ratelimit.Key{
+		{
+			Name: "namespace/" + rayService.Namespace,
+			Limits: ratelimit.Limits{
+				RPS:         config.GetInferenceNamespaceRateLimitRPS(),
+				Burst:       config.GetInferenceNamespaceRateLimitBurst(),
+				MaxInFlight: config.GetInferenceNamespaceMaxInFlight(),
+			},
+		},
+		{
+			Name:   "service/" + rayService.Namespace + "/" + rayService.Name,
+			Limits: serviceLimits,
+		},
+	}
+}
+
+// acquireLimits takes a slot from the namespace and rayservice limiters, release must be called when the request is done
+func (Ih *InferenceHandler) acquireLimits(rayService *rayv1.RayService) (func(), error) {
+	release, retryAfter, ok := Ih.Limiters.Acquire(limitKeys(rayService)...)
+	if !ok {
+		return nil, &RateLimitedError{
+			Scope:      rayService.Namespace + "/" + rayService.Name,
+			RetryAfter: int(math.Ceil(retryAfter.Seconds())),
+		}
+	}
+	return release, nil
+}
+
+// setRetryAfter sets the Retry-After header when err is a RateLimitedError, QuotaExceededError or CircuitOpenError
+func setRetryAfter(c *gin.Context, err error) {
+	var limited *RateLimitedError
+	if errors.As(err, &limited) {
+		c.Header("Retry-After", strconv.Itoa(limited.RetryAfter))
+	}
+	var circuitOpen *CircuitOpenError
+	if errors.As(err, &circuitOpen) {
+		c.Header("Retry-After", strconv.Itoa(circuitOpen.RetryAfter))
+	}
+	var quotaExceeded *QuotaExceededError
+	if errors.As(err, &quotaExceeded) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(quotaExceeded.ResetAt).Seconds()))))
+	}
+}
+
+// RateLimitsHandler returns the current state of every inference limiter
+func (Ih *InferenceHandler) RateLimitsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, Ih.Limiters.States())
+}
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"datatunerx-server/config"
+
+	"github.com/gin-gonic/gin"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/util/retry"
+)
+
+// rayServiceKind names the rayservices in validation errors
+var rayServiceKind = rayv1.SchemeGroupVersion.WithKind("RayService").GroupKind()
+
+// RayServicePatchRequest is the body accepted by PatchRayServiceHandler, only the fields set are changed.
+// Resources are merged by resource name into the existing requests and limits.
+type RayServicePatchRequest struct {
+	// WorkerGroup selects the worker group WorkerReplicas and WorkerResources apply to, all groups when empty
+	WorkerGroup     string                   `json:"workerGroup,omitempty"`
+	WorkerReplicas  *int32                   `json:"workerReplicas,omitempty"`
+	WorkerResources *v1.ResourceRequirements `json:"workerResources,omitempty"`
+	HeadResources   *v1.ResourceRequirements `json:"headResources,omitempty"`
+	// ServeReplicas sets NumReplicas of every serve deployment
+	ServeReplicas *int32 `json:"serveReplicas,omitempty"`
+}
+
+// InvalidPatchError is returned when a patch does not apply to the rayservice
+type InvalidPatchError struct {
+	Message string
+}
+
+func (e *InvalidPatchError) Error() string {
+	return e.Message
+}
+
+// isEmpty reports whether the patch changes nothing
+func (p *RayServicePatchRequest) isEmpty() bool {
+	return p.WorkerReplicas == nil && p.WorkerResources == nil && p.HeadResources == nil && p.ServeReplicas == nil
+}
+
+// Validate checks the replica counts and GPUs against the limits the create request is held to
+func (p *RayServicePatchRequest) Validate() field.ErrorList {
+	var errs field.ErrorList
+	if p.WorkerReplicas != nil {
+		if maxReplicas := config.GetRayServiceMaxWorkerReplicas(); *p.WorkerReplicas < 0 || *p.WorkerReplicas > maxReplicas {
+			errs = append(errs, field.Invalid(field.NewPath("workerReplicas"), *p.WorkerReplicas, fmt.Sprintf("must be between 0 and %d", maxReplicas)))
+		}
+	}
+	if p.WorkerResources != nil {
+		if gpus, ok := p.WorkerResources.Limits[resourceGPU]; ok {
+			if maxGPUs := config.GetRayServiceMaxGPUsPerWorker(); gpus.Sign() < 0 || gpus.Value() > maxGPUs {
+				errs = append(errs, field.Invalid(field.NewPath("workerResources", "limits").Key(string(resourceGPU)), gpus.String(), fmt.Sprintf("must be between 0 and %d", maxGPUs)))
+			}
+		}
+	}
+	if p.ServeReplicas != nil && *p.ServeReplicas < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("serveReplicas"), *p.ServeReplicas, "must be at least 1"))
+	}
+	return errs
+}
+
+// Apply changes the rayservice in place
+func (p *RayServicePatchRequest) Apply(rayService *rayv1.RayService) error {
+	if p.WorkerReplicas != nil || p.WorkerResources != nil {
+		matched := false
+		for i := range rayService.Spec.RayClusterSpec.WorkerGroupSpecs {
+			group := &rayService.Spec.RayClusterSpec.WorkerGroupSpecs[i]
+			if p.WorkerGroup != "" && group.GroupName != p.WorkerGroup {
+				continue
+			}
+			matched = true
+			if p.WorkerReplicas != nil {
+				scaleWorkerGroup(group, *p.WorkerReplicas)
+			}
+			if p.WorkerResources != nil {
+				for j := range group.Template.Spec.Containers {
+					mergeResources(&group.Template.Spec.Containers[j].Resources, *p.WorkerResources)
+				}
+			}
+		}
+		if !matched {
+			if p.WorkerGroup != "" {
+				return &InvalidPatchError{Message: fmt.Sprintf("rayservice %s has no worker group %s", rayService.Name, p.WorkerGroup)}
+			}
+			return &InvalidPatchError{Message: fmt.Sprintf("rayservice %s has no worker groups", rayService.Name)}
+		}
+	}
+	if p.HeadResources != nil {
+		containers := rayService.Spec.RayClusterSpec.HeadGroupSpec.Template.Spec.Containers
+		for i := range containers {
+			mergeResources(&containers[i].Resources, *p.HeadResources)
+		}
+	}
+	if p.ServeReplicas != nil {
+		serveConfigs := rayService.Spec.ServeDeploymentGraphSpec.ServeConfigSpecs
+		if len(serveConfigs) == 0 {
+			return &InvalidPatchError{Message: fmt.Sprintf("rayservice %s defines no serve deployments in serveConfig, edit serveConfigV2 instead", rayService.Name)}
+		}
+		for i := range serveConfigs {
+			replicas := *p.ServeReplicas
+			serveConfigs[i].NumReplicas = &replicas
+		}
+	}
+	return nil
+}
+
+// scaleWorkerGroup sets the replicas of a worker group, widening its min and max replicas if needed
+func scaleWorkerGroup(group *rayv1.WorkerGroupSpec, replicas int32) {
+	group.Replicas = &replicas
+	if group.MinReplicas != nil && *group.MinReplicas > replicas {
+		minReplicas := replicas
+		group.MinReplicas = &minReplicas
+	}
+	if group.MaxReplicas != nil && *group.MaxReplicas < replicas {
+		maxReplicas := replicas
+		group.MaxReplicas = &maxReplicas
+	}
+}
+
+// mergeResources overwrites the requests and limits named in patch
+func mergeResources(resources *v1.ResourceRequirements, patch v1.ResourceRequirements) {
+	if len(patch.Requests) > 0 && resources.Requests == nil {
+		resources.Requests = v1.ResourceList{}
+	}
+	for name, quantity := range patch.Requests {
+		resources.Requests[name] = quantity
+	}
+	if len(patch.Limits) > 0 && resources.Limits == nil {
+		resources.Limits = v1.ResourceList{}
+	}
+	for name, quantity := range patch.Limits {
+		resources.Limits[name] = quantity
+	}
+}
+
+// GetRayServiceHandler returns an inference rayservice
+func (rh *ResourceHandler) GetRayServiceHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("serviceName")
+	rayService, err := rh.RayServiceCache.Get(c.Request.Context(), namespace, name)
+	if err == nil && !isInferenceService(rayService) {
+		err = apierrors.NewNotFound(rayv1.Resource("rayservices"), name)
+	}
+	if err != nil {
+		writeError(c, rayServiceErrorStatus(err), fmt.Sprintf("Failed to get rayservice: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, rayService)
+}
+
+// DeleteRayServiceHandler deletes an inference rayservice
+func (rh *ResourceHandler) DeleteRayServiceHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("serviceName")
+	rayService, err := rh.getInferenceRayService(c.Request.Context(), namespace, name)
+	if err != nil {
+		writeError(c, rayServiceErrorStatus(err), fmt.Sprintf("Failed to get rayservice: %v", err))
+		return
+	}
+
+	// 以 UID 为前提条件，避免误删同名的新对象
+	err = rh.RayClients.Clientset.RayV1().RayServices(namespace).Delete(c.Request.Context(), name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(rayService.UID)),
+	})
+	if err != nil {
+		writeError(c, rayServiceErrorStatus(err), fmt.Sprintf("Failed to delete rayservice: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("rayservice %s/%s deleted", namespace, name)})
+}
+
+// PatchRayServiceHandler scales an inference rayservice or changes its resources
+func (rh *ResourceHandler) PatchRayServiceHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("serviceName")
+	var patch RayServicePatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if patch.isEmpty() {
+		writeError(c, http.StatusBadRequest, "patch must set at least one of 'workerReplicas', 'workerResources', 'headResources' or 'serveReplicas'")
+		return
+	}
+	if errs := patch.Validate(); len(errs) > 0 {
+		writeInvalid(c, rayServiceKind, name, errs)
+		return
+	}
+
+	var updated *rayv1.RayService
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		rayService, err := rh.getInferenceRayService(c.Request.Context(), namespace, name)
+		if err != nil {
+			return err
+		}
+		if err := patch.Apply(rayService); err != nil {
+			return err
+		}
+		updated, err = rh.RayClients.Clientset.RayV1().RayServices(namespace).Update(c.Request.Context(), rayService, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		writeError(c, rayServiceErrorStatus(err), fmt.Sprintf("Failed to update rayservice: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// getInferenceRayService reads a rayservice from the API server, bypassing the cache, and checks that it
+// carries the inference service label
+func (rh *ResourceHandler) getInferenceRayService(ctx context.Context, namespace, name string) (*rayv1.RayService, error) {
+	rayService, err := rh.RayClients.Clientset.RayV1().RayServices(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !isInferenceService(rayService) {
+		return nil, apierrors.NewNotFound(rayv1.Resource("rayservices"), name)
+	}
+	return rayService, nil
+}
+
+// rayServiceErrorStatus maps patch and API errors to a status code
+func rayServiceErrorStatus(err error) int {
+	var invalidPatch *InvalidPatchError
+	var apiStatus apierrors.APIStatus
+	switch {
+	case errors.As(err, &invalidPatch):
+		return http.StatusBadRequest
+	case errors.As(err, &apiStatus) && apiStatus.Status().Code != 0:
+		return int(apiStatus.Status().Code)
+	default:
+		return http.StatusInternalServerError
+	}
+}
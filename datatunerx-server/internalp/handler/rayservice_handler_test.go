@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func newTestRayServiceParams() rayServiceParams {
+	sizing, err := defaultRayServiceSizing()
+	if err != nil {
+		panic(err)
+	}
+	return rayServiceParams{
+		name:           "llama",
+		llmCheckpoint:  "checkpoint",
+		image:          "inference:latest",
+		llmPath:        "/models/llama",
+		checkpointPath: "/checkpoints/llama",
+		sizing:         sizing,
+	}
+}
+
+func newTestRayService() *rayv1.RayService {
+	return (&ResourceHandler{}).buildRayServiceObject("default", newTestRayServiceParams())
+}
+
+func TestRayServicePatchApply(t *testing.T) {
+	rayService := newTestRayService()
+	workerReplicas, serveReplicas := int32(3), int32(2)
+	patch := RayServicePatchRequest{
+		WorkerReplicas: &workerReplicas,
+		ServeReplicas:  &serveReplicas,
+		WorkerResources: &v1.ResourceRequirements{
+			Limits: v1.ResourceList{"nvidia.com/gpu": resource.MustParse("2")},
+		},
+	}
+	if errs := patch.Validate(); len(errs) > 0 {
+		t.Fatalf("Unexpected validation errors: %v", errs)
+	}
+	if err := patch.Apply(rayService); err != nil {
+		t.Fatalf("Unexpected apply error: %v", err)
+	}
+
+	group := rayService.Spec.RayClusterSpec.WorkerGroupSpecs[0]
+	if *group.Replicas != 3 || *group.MinReplicas != 1 || *group.MaxReplicas != 3 {
+		t.Errorf("Expected 3 replicas within [1, 3], got %d within [%d, %d]", *group.Replicas, *group.MinReplicas, *group.MaxReplicas)
+	}
+	if replicas := *rayService.Spec.ServeDeploymentGraphSpec.ServeConfigSpecs[0].NumReplicas; replicas != 2 {
+		t.Errorf("Expected 2 serve replicas, got %d", replicas)
+	}
+	limits := group.Template.Spec.Containers[0].Resources.Limits
+	if gpu := limits["nvidia.com/gpu"]; gpu.Value() != 2 {
+		t.Errorf("Expected 2 gpus, got %s", gpu.String())
+	}
+	if memory := limits[v1.ResourceMemory]; memory.String() != "48Gi" {
+		t.Errorf("Expected the memory limit to be kept, got %s", memory.String())
+	}
+}
+
+func TestRayServicePatchUnknownWorkerGroup(t *testing.T) {
+	rayService := newTestRayService()
+	replicas := int32(2)
+	patch := RayServicePatchRequest{WorkerGroup: "missing", WorkerReplicas: &replicas}
+	err := patch.Apply(rayService)
+	if _, ok := err.(*InvalidPatchError); !ok {
+		t.Fatalf("Expected an InvalidPatchError, got %v", err)
+	}
+}
+
+func TestRayServicePatchValidate(t *testing.T) {
+	negative, zero, tooMany := int32(-1), int32(0), int32(1000)
+	invalid := []RayServicePatchRequest{
+		{WorkerReplicas: &negative},
+		{WorkerReplicas: &tooMany},
+		{ServeReplicas: &zero},
+		{WorkerResources: &v1.ResourceRequirements{Limits: v1.ResourceList{resourceGPU: resource.MustParse("64")}}},
+	}
+	for i, patch := range invalid {
+		if errs := patch.Validate(); len(errs) == 0 {
+			t.Errorf("Expected patch %d to be invalid", i)
+		}
+	}
+	if !(&RayServicePatchRequest{}).isEmpty() {
+		t.Errorf("Expected a patch without fields to be empty")
+	}
+	if errs := (&RayServicePatchRequest{WorkerReplicas: &zero}).Validate(); len(errs) > 0 {
+		t.Errorf("Expected scaling workers to zero to be valid, got %v", errs)
+	}
+}
+
+func TestRayServiceSizingResolve(t *testing.T) {
+	defaults, err := defaultRayServiceSizing()
+	if err != nil {
+		t.Fatalf("Unexpected error reading the default sizing: %v", err)
+	}
+	workerReplicas, maxReplicas, gpusPerWorker, serveReplicas := int32(1), int32(2), int64(2), int32(4)
+	gpusPerReplica := 0.5
+	request := RayServiceSizing{
+		WorkerResources:   &ResourceQuantities{Limits: map[string]string{"memory": "24Gi"}},
+		WorkerReplicas:    &workerReplicas,
+		WorkerMaxReplicas: &maxReplicas,
+		GPUsPerWorker:     &gpusPerWorker,
+		ServeReplicas:     &serveReplicas,
+		GPUsPerReplica:    &gpusPerReplica,
+	}
+	sizing, errs := request.resolve(defaults)
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	resources := sizing.workerResources()
+	if memory := resources.Requests[v1.ResourceMemory]; memory.String() != "24Gi" {
+		t.Errorf("Expected the default memory request to follow the lowered limit, got %s", memory.String())
+	}
+	if gpu := resources.Limits[resourceGPU]; gpu.Value() != 2 {
+		t.Errorf("Expected 2 gpus per worker, got %s", gpu.String())
+	}
+	if sizing.workerMinReplicas != 1 || sizing.workerMaxReplicas != 2 {
+		t.Errorf("Expected worker replicas within [1, 2], got [%d, %d]", sizing.workerMinReplicas, sizing.workerMaxReplicas)
+	}
+
+	tooManyReplicas := int32(8)
+	invalid := []RayServiceSizing{
+		{HeadResources: &ResourceQuantities{Requests: map[string]string{"cpu": "two"}}},
+		{WorkerResources: &ResourceQuantities{Requests: map[string]string{"memory": "64Gi"}, Limits: map[string]string{"memory": "32Gi"}}},
+		{WorkerReplicas: &workerReplicas, WorkerMaxReplicas: &workerReplicas, ServeReplicas: &tooManyReplicas},
+	}
+	for i, request := range invalid {
+		if _, errs := request.resolve(defaults); len(errs) == 0 {
+			t.Errorf("Expected sizing %d to be invalid", i)
+		}
+	}
+
+	// 所有无效字段一次报告
+	negative := int32(-1)
+	request = RayServiceSizing{
+		HeadResources:     &ResourceQuantities{Limits: map[string]string{"cpu": "lots"}},
+		WorkerMinReplicas: &negative,
+		ServeReplicas:     &negative,
+	}
+	if _, errs := request.resolve(defaults); len(errs) != 3 {
+		t.Errorf("Expected 3 invalid fields, got %v", errs)
+	}
+}
+
+func TestCreateRayServiceRequestValidate(t *testing.T) {
+	valid := CreateRayServiceRequest{Name: "llama-7b", LLMCheckpoint: "checkpoint"}
+	if errs := valid.validate(); len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	invalid := CreateRayServiceRequest{Name: "Llama_7B"}
+	errs := invalid.validate()
+	fields := map[string]bool{}
+	for _, err := range errs {
+		fields[err.Field] = true
+	}
+	if !fields["name"] || !fields["llmCheckpoint"] {
+		t.Errorf("Expected name and llmCheckpoint to be invalid, got %v", errs)
+	}
+	tooLong := CreateRayServiceRequest{Name: strings.Repeat("a", maxRayServiceNameLength+1), LLMCheckpoint: "checkpoint"}
+	if errs := tooLong.validate(); len(errs) != 1 {
+		t.Errorf("Expected a name leaving no room for the serve service suffix to be invalid, got %v", errs)
+	}
+}
+
+const testRayServiceTemplate = `apiVersion: ray.io/v1
+kind: RayService
+metadata:
+  name: ignored
+  labels:
+    team: nlp
+spec:
+  rayClusterConfig:
+    rayVersion: "2.9.0"
+    headGroupSpec:
+      rayStartParams: {}
+      template:
+        spec:
+          containers:
+          - name: ray-head
+            image: {{ .image }}
+    workerGroupSpecs:
+    - groupName: worker
+      replicas: {{ .workerReplicas }}
+      minReplicas: {{ .workerMinReplicas }}
+      maxReplicas: {{ .workerMaxReplicas }}
+      rayStartParams: {}
+      template:
+        spec:
+          tolerations:
+          - key: nvidia.com/gpu
+            operator: Exists
+          containers:
+          - name: ray-worker
+            image: {{ .image }}
+            env:
+            - name: BASE_MODEL_DIR
+              value: {{ .llmPath }}
+            resources:
+              limits:
+                memory: {{ .workerResources.limits.memory }}
+  serveConfig:
+    importPath: inference.deployment
+    deployments:
+    - name: LlamaDeployment
+      numReplicas: {{ .serveReplicas }}
+      rayActorOptions:
+        numGpus: {{ .gpusPerReplica }}
+  serveService:
+    metadata:
+      name: {{ .name }}-service
+`
+
+func TestRenderRayServiceTemplate(t *testing.T) {
+	params := newTestRayServiceParams()
+	rayService, err := renderRayServiceTemplate(testRayServiceTemplate, "default", params)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rayService.Name != "llama" || rayService.Namespace != "default" {
+		t.Errorf("Expected the request name and namespace, got %s/%s", rayService.Namespace, rayService.Name)
+	}
+	if !isInferenceService(rayService) || rayService.Labels["team"] != "nlp" {
+		t.Errorf("Expected the template labels plus the inference service label, got %v", rayService.Labels)
+	}
+	if rayService.Annotations[annotationLLMCheckpoint] != "checkpoint" {
+		t.Errorf("Expected the checkpoint annotation, got %v", rayService.Annotations)
+	}
+	worker := rayService.Spec.RayClusterSpec.WorkerGroupSpecs[0]
+	if *worker.Replicas != params.sizing.workerReplicas || len(worker.Template.Spec.Tolerations) != 1 {
+		t.Errorf("Expected the rendered worker group, got %+v", worker)
+	}
+	if memory := worker.Template.Spec.Containers[0].Resources.Limits[v1.ResourceMemory]; memory.String() != "48Gi" {
+		t.Errorf("Expected the default memory limit, got %s", memory.String())
+	}
+	if rayService.Spec.ServeService.Name != "llama-service" {
+		t.Errorf("Expected the serve service llama-service, got %s", rayService.Spec.ServeService.Name)
+	}
+
+	withOwner := testRayServiceTemplate + "      annotations:\n        owner: {{ .owner }}\n"
+	if _, err := renderRayServiceTemplate(withOwner, "default", params); err == nil {
+		t.Errorf("Expected a template referencing a missing value to fail")
+	}
+	params.fields = map[string]interface{}{"owner": "nlp-team"}
+	rayService, err = renderRayServiceTemplate(withOwner, "default", params)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if owner := rayService.Spec.ServeService.Annotations["owner"]; owner != "nlp-team" {
+		t.Errorf("Expected the owner request field in the serve service annotations, got %q", owner)
+	}
+
+	// 请求字段中的换行不能注入 YAML
+	params.fields = map[string]interface{}{"owner": "nlp-team\n        injected: \"true\""}
+	rayService, err = renderRayServiceTemplate(withOwner, "default", params)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations := rayService.Spec.ServeService.Annotations
+	if _, ok := annotations["injected"]; ok || annotations["owner"] != params.fields["owner"] {
+		t.Errorf("Expected the owner request field to be kept as a single value, got %v", annotations)
+	}
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+
+	"datatunerx-server/config"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// resourceGPU is the extended resource the worker GPUs are requested as
+const resourceGPU v1.ResourceName = "nvidia.com/gpu"
+
+// RayServiceSizing is the sizing part of the create request, unset fields fall back to the configured defaults
+type RayServiceSizing struct {
+	HeadResources   *ResourceQuantities `json:"headResources,omitempty"`
+	WorkerResources *ResourceQuantities `json:"workerResources,omitempty"`
+	WorkerReplicas  *int32              `json:"workerReplicas,omitempty"`
+	// WorkerMinReplicas and WorkerMaxReplicas default to WorkerReplicas
+	WorkerMinReplicas *int32 `json:"workerMinReplicas,omitempty"`
+	WorkerMaxReplicas *int32 `json:"workerMaxReplicas,omitempty"`
+	// GPUsPerWorker is the nvidia.com/gpu limit of a worker pod
+	GPUsPerWorker *int64 `json:"gpusPerWorker,omitempty"`
+	ServeReplicas *int32 `json:"serveReplicas,omitempty"`
+	// GPUsPerReplica is the NumGpus of a serve replica, fractions let replicas share a GPU
+	GPUsPerReplica *float64 `json:"gpusPerReplica,omitempty"`
+}
+
+// ResourceQuantities are requests and limits by resource name, e.g. {"cpu": "4", "memory": "32Gi"}
+type ResourceQuantities struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+// rayServiceSizing is the resolved sizing buildRayServiceObject builds the rayservice with
+type rayServiceSizing struct {
+	head              v1.ResourceRequirements
+	worker            v1.ResourceRequirements
+	workerReplicas    int32
+	workerMinReplicas int32
+	workerMaxReplicas int32
+	gpusPerWorker     int64
+	serveReplicas     int32
+	gpusPerReplica    float64
+}
+
+// defaultRayServiceSizing reads the default sizing from the config, failing if a quantity is invalid
+func defaultRayServiceSizing() (rayServiceSizing, error) {
+	head, err := parseResourceRequirements(config.GetRayServiceHeadResources())
+	if err != nil {
+		return rayServiceSizing{}, fmt.Errorf("invalid default head resources: %v", err)
+	}
+	worker, err := parseResourceRequirements(config.GetRayServiceWorkerResources())
+	if err != nil {
+		return rayServiceSizing{}, fmt.Errorf("invalid default worker resources: %v", err)
+	}
+	replicas := config.GetRayServiceWorkerReplicas()
+	return rayServiceSizing{
+		head:              head,
+		worker:            worker,
+		workerReplicas:    replicas,
+		workerMinReplicas: replicas,
+		workerMaxReplicas: replicas,
+		gpusPerWorker:     config.GetRayServiceGPUsPerWorker(),
+		serveReplicas:     config.GetRayServiceServeReplicas(),
+		gpusPerReplica:    config.GetRayServiceGPUsPerReplica(),
+	}, nil
+}
+
+func parseResourceRequirements(cpuRequest, cpuLimit, memoryRequest, memoryLimit string) (v1.ResourceRequirements, error) {
+	requirements := v1.ResourceRequirements{Requests: v1.ResourceList{}, Limits: v1.ResourceList{}}
+	quantities := []struct {
+		list  v1.ResourceList
+		name  v1.ResourceName
+		value string
+	}{
+		{requirements.Requests, v1.ResourceCPU, cpuRequest},
+		{requirements.Limits, v1.ResourceCPU, cpuLimit},
+		{requirements.Requests, v1.ResourceMemory, memoryRequest},
+		{requirements.Limits, v1.ResourceMemory, memoryLimit},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return v1.ResourceRequirements{}, fmt.Errorf("%s %q: %v", q.name, q.value, err)
+		}
+		q.list[q.name] = quantity
+	}
+	return requirements, nil
+}
+
+// resolve applies the requested sizing over the defaults and validates the result against the configured maximums
+func (s *RayServiceSizing) resolve(defaults rayServiceSizing) (rayServiceSizing, field.ErrorList) {
+	sizing := defaults
+	var errs field.ErrorList
+	var fieldErrs field.ErrorList
+	sizing.head, fieldErrs = s.HeadResources.merge(field.NewPath("headResources"), defaults.head)
+	errs = append(errs, fieldErrs...)
+	sizing.worker, fieldErrs = s.WorkerResources.merge(field.NewPath("workerResources"), defaults.worker)
+	errs = append(errs, fieldErrs...)
+	if s.WorkerReplicas != nil {
+		sizing.workerReplicas = *s.WorkerReplicas
+		sizing.workerMinReplicas = *s.WorkerReplicas
+		sizing.workerMaxReplicas = *s.WorkerReplicas
+	}
+	if s.WorkerMinReplicas != nil {
+		sizing.workerMinReplicas = *s.WorkerMinReplicas
+	}
+	if s.WorkerMaxReplicas != nil {
+		sizing.workerMaxReplicas = *s.WorkerMaxReplicas
+	}
+	if s.GPUsPerWorker != nil {
+		sizing.gpusPerWorker = *s.GPUsPerWorker
+	}
+	if s.ServeReplicas != nil {
+		sizing.serveReplicas = *s.ServeReplicas
+	}
+	if s.GPUsPerReplica != nil {
+		sizing.gpusPerReplica = *s.GPUsPerReplica
+	}
+
+	if sizing.workerMinReplicas < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("workerMinReplicas"), sizing.workerMinReplicas, "must not be negative"))
+	}
+	if maxReplicas := config.GetRayServiceMaxWorkerReplicas(); sizing.workerMaxReplicas > maxReplicas {
+		errs = append(errs, field.Invalid(field.NewPath("workerMaxReplicas"), sizing.workerMaxReplicas, fmt.Sprintf("must be at most %d", maxReplicas)))
+	}
+	if sizing.workerReplicas < sizing.workerMinReplicas || sizing.workerReplicas > sizing.workerMaxReplicas {
+		errs = append(errs, field.Invalid(field.NewPath("workerReplicas"), sizing.workerReplicas,
+			fmt.Sprintf("must be between workerMinReplicas %d and workerMaxReplicas %d", sizing.workerMinReplicas, sizing.workerMaxReplicas)))
+	}
+	if maxGPUs := config.GetRayServiceMaxGPUsPerWorker(); sizing.gpusPerWorker < 0 || sizing.gpusPerWorker > maxGPUs {
+		errs = append(errs, field.Invalid(field.NewPath("gpusPerWorker"), sizing.gpusPerWorker, fmt.Sprintf("must be between 0 and %d", maxGPUs)))
+	}
+	if sizing.serveReplicas < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("serveReplicas"), sizing.serveReplicas, "must be at least 1"))
+	}
+	if sizing.gpusPerReplica < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("gpusPerReplica"), sizing.gpusPerReplica, "must not be negative"))
+	}
+	if len(errs) > 0 {
+		return rayServiceSizing{}, errs
+	}
+	// serve 副本需要的 GPU 不能超过 worker 最多能提供的 GPU，否则副本永远无法调度
+	if required, available := float64(sizing.serveReplicas)*sizing.gpusPerReplica, float64(sizing.workerMaxReplicas)*float64(sizing.gpusPerWorker); required > available {
+		return rayServiceSizing{}, field.ErrorList{field.Invalid(field.NewPath("serveReplicas"), sizing.serveReplicas,
+			fmt.Sprintf("%d serve replicas with %g GPUs each need %g GPUs, but at most %d workers with %d GPUs each provide %g",
+				sizing.serveReplicas, sizing.gpusPerReplica, required, sizing.workerMaxReplicas, sizing.gpusPerWorker, available))}
+	}
+	return sizing, nil
+}
+
+// merge parses the quantities and overwrites the defaults with them, requests must not exceed limits
+func (rq *ResourceQuantities) merge(path *field.Path, defaults v1.ResourceRequirements) (v1.ResourceRequirements, field.ErrorList) {
+	merged := v1.ResourceRequirements{Requests: defaults.Requests.DeepCopy(), Limits: defaults.Limits.DeepCopy()}
+	if merged.Requests == nil {
+		merged.Requests = v1.ResourceList{}
+	}
+	if merged.Limits == nil {
+		merged.Limits = v1.ResourceList{}
+	}
+	var errs field.ErrorList
+	if rq != nil {
+		errs = append(errs, parseQuantities(path.Child("requests"), rq.Requests, merged.Requests)...)
+		errs = append(errs, parseQuantities(path.Child("limits"), rq.Limits, merged.Limits)...)
+	}
+	if len(errs) > 0 {
+		return v1.ResourceRequirements{}, errs
+	}
+	for _, name := range sortedResourceNames(merged.Requests) {
+		request := merged.Requests[name]
+		limit, ok := merged.Limits[name]
+		if !ok || request.Cmp(limit) <= 0 {
+			continue
+		}
+		// 只调小了 limit 时，默认的 request 随之调小
+		if rq == nil || rq.Requests[string(name)] == "" {
+			merged.Requests[name] = limit
+			continue
+		}
+		errs = append(errs, field.Invalid(path.Child("requests").Key(string(name)), request.String(),
+			fmt.Sprintf("must not exceed the limit %s", limit.String())))
+	}
+	return merged, errs
+}
+
+func parseQuantities(path *field.Path, values map[string]string, list v1.ResourceList) field.ErrorList {
+	var errs field.ErrorList
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		quantity, err := resource.ParseQuantity(values[name])
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Key(name), values[name], err.Error()))
+			continue
+		}
+		if quantity.Sign() < 0 {
+			errs = append(errs, field.Invalid(path.Key(name), values[name], "must not be negative"))
+			continue
+		}
+		list[v1.ResourceName(name)] = quantity
+	}
+	return errs
+}
+
+// sortedResourceNames returns the names of the list in order, so that errors are reported in a stable order
+func sortedResourceNames(list v1.ResourceList) []v1.ResourceName {
+	names := make([]v1.ResourceName, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// workerResources returns the worker resources with the GPU limit set
+func (s rayServiceSizing) workerResources() v1.ResourceRequirements {
+	resources := v1.ResourceRequirements{Requests: s.worker.Requests.DeepCopy(), Limits: s.worker.Limits.DeepCopy()}
+	if resources.Limits == nil {
+		resources.Limits = v1.ResourceList{}
+	}
+	delete(resources.Requests, resourceGPU)
+	delete(resources.Limits, resourceGPU)
+	if s.gpusPerWorker > 0 {
+		resources.Limits[resourceGPU] = *resource.NewQuantity(s.gpusPerWorker, resource.DecimalSI)
+	}
+	return resources
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"datatunerx-server/config"
+
+	"github.com/DataTunerX/utility-server/parser"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// rayServiceTemplateDataKey is the key of the manifest template in the rayservice template ConfigMap
+const rayServiceTemplateDataKey = "rayservice.yaml"
+
+// newRayServiceObject builds the rayservice from the template of the namespace or the cluster-wide template,
+// and from buildRayServiceObject when there is none
+func (rh *ResourceHandler) newRayServiceObject(ctx context.Context, namespace string, params rayServiceParams) (*rayv1.RayService, error) {
+	text, ok, err := rh.getRayServiceTemplate(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rayservice template: %v", err)
+	}
+	if !ok {
+		return rh.buildRayServiceObject(namespace, params), nil
+	}
+	return renderRayServiceTemplate(text, namespace, params)
+}
+
+// getRayServiceTemplate reads the manifest template of the namespace, falling back to the one in
+// config.GetRayServiceTemplateNamespace
+func (rh *ResourceHandler) getRayServiceTemplate(ctx context.Context, namespace string) (string, bool, error) {
+	namespaces := []string{namespace}
+	if clusterNamespace := config.GetRayServiceTemplateNamespace(); clusterNamespace != "" && clusterNamespace != namespace {
+		namespaces = append(namespaces, clusterNamespace)
+	}
+	for _, ns := range namespaces {
+		configMap, err := rh.KubeClients.Clientset.CoreV1().ConfigMaps(ns).Get(ctx, config.GetRayServiceTemplateConfigMap(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if text := configMap.Data[rayServiceTemplateDataKey]; strings.TrimSpace(text) != "" {
+			return text, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// renderRayServiceTemplate renders the template with the request fields, the checkpoint image and the resolved sizing and decodes it.
+// The name, namespace, inference service label and checkpoint annotation are always set by the server.
+func renderRayServiceTemplate(text, namespace string, params rayServiceParams) (*rayv1.RayService, error) {
+	rendered, err := parser.ReplaceTemplate(text, rayServiceTemplateData(namespace, params))
+	if err != nil {
+		return nil, fmt.Errorf("failed to render rayservice template: %v", err)
+	}
+	// text/template 对 map 中不存在的字段输出 <no value>
+	if strings.Contains(rendered, "<no value>") {
+		return nil, fmt.Errorf("rayservice template references a value the request does not provide")
+	}
+	rayService := &rayv1.RayService{}
+	if err := yaml.UnmarshalStrict([]byte(rendered), rayService); err != nil {
+		return nil, fmt.Errorf("failed to decode rendered rayservice template: %v", err)
+	}
+	if rayService.Kind != "" && rayService.Kind != "RayService" {
+		return nil, fmt.Errorf("rayservice template renders a %s, not a RayService", rayService.Kind)
+	}
+	if rayService.Spec.ServeService == nil {
+		return nil, fmt.Errorf("rayservice template must define spec.serveService, inference requests are proxied through it")
+	}
+
+	rayService.Name = params.name
+	rayService.Namespace = namespace
+	inferenceLabels, err := labels.ConvertSelectorToLabelsMap(config.GetInferenceServiceLabel())
+	if err != nil {
+		return nil, fmt.Errorf("invalid inference service label %q: %v", config.GetInferenceServiceLabel(), err)
+	}
+	if rayService.Labels == nil {
+		rayService.Labels = map[string]string{}
+	}
+	for key, value := range inferenceLabels {
+		rayService.Labels[key] = value
+	}
+	if rayService.Annotations == nil {
+		rayService.Annotations = map[string]string{}
+	}
+	rayService.Annotations[annotationLLMCheckpoint] = params.llmCheckpoint
+	return rayService, nil
+}
+
+// rayServiceTemplateData is the data templates are rendered with: the request fields, with the sizing fields
+// replaced by their resolved values, the checkpoint image and paths, and the namespace. Resources are maps of
+// requests and limits, e.g. {{ .workerResources.limits.memory }}. The other request fields are rendered as JSON
+// values, strings are quoted, so that they cannot add YAML of their own.
+func rayServiceTemplateData(namespace string, params rayServiceParams) map[string]interface{} {
+	sizing := params.sizing
+	templateData := make(map[string]interface{}, len(params.fields)+15)
+	for key, value := range params.fields {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		templateData[key] = string(encoded)
+	}
+	templateData["name"] = params.name
+	templateData["llmCheckpoint"] = params.llmCheckpoint
+	templateData["image"] = params.image
+	templateData["llmPath"] = params.llmPath
+	templateData["checkpointPath"] = params.checkpointPath
+	templateData["namespace"] = namespace
+	templateData["headResources"] = resourceRequirementsData(sizing.head)
+	templateData["workerResources"] = resourceRequirementsData(sizing.workerResources())
+	templateData["workerReplicas"] = sizing.workerReplicas
+	templateData["workerMinReplicas"] = sizing.workerMinReplicas
+	templateData["workerMaxReplicas"] = sizing.workerMaxReplicas
+	templateData["gpusPerWorker"] = sizing.gpusPerWorker
+	templateData["serveReplicas"] = sizing.serveReplicas
+	templateData["gpusPerReplica"] = sizing.gpusPerReplica
+	return templateData
+}
+
+func resourceRequirementsData(resources v1.ResourceRequirements) map[string]map[string]string {
+	data := map[string]map[string]string{"requests": {}, "limits": {}}
+	for name, quantity := range resources.Requests {
+		data["requests"][string(name)] = quantity.String()
+	}
+	for name, quantity := range resources.Limits {
+		data["limits"][string(name)] = quantity.String()
+	}
+	return data
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"datatunerx-server/pkg/guardrail"
+
+	"github.com/gin-gonic/gin"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ServiceNotReadyError is returned when a rayservice exists but its serve application is not healthy
+type ServiceNotReadyError struct {
+	Service       string
+	ServiceStatus string
+	Reason        string
+	Applications  map[string]rayv1.AppStatus
+}
+
+func (e *ServiceNotReadyError) Error() string {
+	return fmt.Sprintf("rayservice %s is not serving: %s", e.Service, e.Reason)
+}
+
+// UpstreamError is returned when the serve application answers with a non-2xx status or an unusable body
+type UpstreamError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("upstream error: %s", e.Message)
+	}
+	return fmt.Sprintf("upstream returned %d: %s", e.StatusCode, e.Message)
+}
+
+// newUpstreamStatusError builds an UpstreamError from a non-2xx response, keeping the start of its body
+func newUpstreamStatusError(resp *http.Response) *UpstreamError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &UpstreamError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+}
+
+// checkServeReady inspects the rayservice status and returns a ServiceNotReadyError unless
+// every serve application is running and none of its deployments is unhealthy
+func checkServeReady(rayService *rayv1.RayService) error {
+	status := rayService.Status
+	notReady := func(reason string) error {
+		return &ServiceNotReadyError{
+			Service:       rayService.Name,
+			ServiceStatus: string(status.ServiceStatus),
+			Reason:        reason,
+			Applications:  status.ActiveServiceStatus.Applications,
+		}
+	}
+
+	applications := status.ActiveServiceStatus.Applications
+	if len(applications) == 0 {
+		if status.ServiceStatus == "" {
+			return notReady("rayservice has not reported any status yet")
+		}
+		return notReady(fmt.Sprintf("no serve application is reported, service status is %s", status.ServiceStatus))
+	}
+
+	// 按名称排序，保证错误信息稳定
+	appNames := make([]string, 0, len(applications))
+	for name := range applications {
+		appNames = append(appNames, name)
+	}
+	sort.Strings(appNames)
+	for _, appName := range appNames {
+		app := applications[appName]
+		if app.Status != rayv1.ApplicationStatusEnum.RUNNING {
+			return notReady(withMessage(fmt.Sprintf("application %s is %s", appName, app.Status), app.Message))
+		}
+		deploymentNames := make([]string, 0, len(app.Deployments))
+		for name := range app.Deployments {
+			deploymentNames = append(deploymentNames, name)
+		}
+		sort.Strings(deploymentNames)
+		for _, deploymentName := range deploymentNames {
+			deployment := app.Deployments[deploymentName]
+			if deployment.Status == rayv1.DeploymentStatusEnum.UNHEALTHY {
+				return notReady(withMessage(fmt.Sprintf("deployment %s of application %s is %s", deploymentName, appName, deployment.Status), deployment.Message))
+			}
+		}
+	}
+	return nil
+}
+
+func withMessage(reason, message string) string {
+	if message == "" {
+		return reason
+	}
+	return reason + ": " + message
+}
+
+// writeInferenceError writes the response for an error returned while serving an inference request
+func writeInferenceError(c *gin.Context, err error) {
+	setRetryAfter(c, err)
+	c.JSON(inferenceErrorResponse(err))
+}
+
+// inferenceErrorResponse maps lookup, readiness, quota, rate limit, circuit breaker, guardrail and upstream errors to a status code and error body
+func inferenceErrorResponse(err error) (int, gin.H) {
+	var quotaExceeded *QuotaExceededError
+	if errors.As(err, &quotaExceeded) {
+		body := errorStatus(http.StatusTooManyRequests, quotaExceeded.Error())
+		body["quota"] = quotaExceeded.Quota
+		body["used"] = quotaExceeded.Used
+		body["resetAt"] = quotaExceeded.ResetAt
+		return http.StatusTooManyRequests, body
+	}
+	var limited *RateLimitedError
+	if errors.As(err, &limited) {
+		body := errorStatus(http.StatusTooManyRequests, limited.Error())
+		body["retryAfter"] = limited.RetryAfter
+		return http.StatusTooManyRequests, body
+	}
+	var notReady *ServiceNotReadyError
+	if errors.As(err, &notReady) {
+		body := errorStatus(http.StatusServiceUnavailable, notReady.Error())
+		body["serviceStatus"] = notReady.ServiceStatus
+		// reason 为 Status 的 ServiceUnavailable，rayservice 未就绪的原因放在 serviceReason
+		body["serviceReason"] = notReady.Reason
+		body["applications"] = notReady.Applications
+		return http.StatusServiceUnavailable, body
+	}
+	var circuitOpen *CircuitOpenError
+	if errors.As(err, &circuitOpen) {
+		body := errorStatus(http.StatusServiceUnavailable, circuitOpen.Error())
+		body["retryAfter"] = circuitOpen.RetryAfter
+		return http.StatusServiceUnavailable, body
+	}
+	var unsupported *UnsupportedRouteError
+	if errors.As(err, &unsupported) {
+		return http.StatusNotImplemented, errorStatus(http.StatusNotImplemented, unsupported.Error())
+	}
+	var violation *guardrail.Violation
+	if errors.As(err, &violation) {
+		status := http.StatusBadRequest
+		if violation.Stage == guardrail.Output {
+			status = http.StatusUnprocessableEntity
+		}
+		body := errorStatus(status, violation.Error())
+		body["guardrail"] = violation
+		return status, body
+	}
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		status := upstreamStatus(upstream)
+		body := errorStatus(status, fmt.Sprintf("Failed to forward request: %v", upstream))
+		body["upstreamStatus"] = upstream.StatusCode
+		return status, body
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, errorStatus(http.StatusGatewayTimeout, fmt.Sprintf("Failed to forward request: %v", err))
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.Timeout() {
+		return http.StatusGatewayTimeout, errorStatus(http.StatusGatewayTimeout, fmt.Sprintf("Failed to forward request: %v", err))
+	}
+	if errors.As(err, &urlErr) {
+		return http.StatusBadGateway, errorStatus(http.StatusBadGateway, fmt.Sprintf("Failed to forward request: %v", err))
+	}
+	if apierrors.IsNotFound(err) {
+		return http.StatusNotFound, errorStatus(http.StatusNotFound, fmt.Sprintf("Failed to get rayservice: %v", err))
+	}
+	return http.StatusInternalServerError, errorStatus(http.StatusInternalServerError, err.Error())
+}
+
+// upstreamStatus passes client errors reported by the deployment through and turns the rest into 502/503
+func upstreamStatus(err *UpstreamError) int {
+	switch {
+	case err.StatusCode == http.StatusServiceUnavailable:
+		return http.StatusServiceUnavailable
+	case err.StatusCode >= 400 && err.StatusCode < 500 && err.StatusCode != http.StatusNotFound:
+		return err.StatusCode
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"datatunerx-server/config"
@@ -16,27 +17,33 @@ import (
 
 	"github.com/DataTunerX/utility-server/logging"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
 )
 
 // ResourceHandler struct contains necessary dependencies
 type ResourceHandler struct {
-	KubeClients k8s.KubernetesClients
-	RayClients  ray.RayClient
+	KubeClients     k8s.KubernetesClients
+	RayClients      ray.RayClient
+	RayServiceCache *ray.RayServiceCache
 }
 
 // NewResourceHandler creates a new instance of ResourceHandler
-func NewResourceHandler(kubeClients k8s.KubernetesClients, rayClients ray.RayClient) *ResourceHandler {
+func NewResourceHandler(kubeClients k8s.KubernetesClients, rayClients ray.RayClient, rayServiceCache *ray.RayServiceCache) *ResourceHandler {
 	return &ResourceHandler{
-		KubeClients: kubeClients,
-		RayClients:  rayClients,
+		KubeClients:     kubeClients,
+		RayClients:      rayClients,
+		RayServiceCache: rayServiceCache,
 	}
 }
 
@@ -65,7 +72,7 @@ func (rh *ResourceHandler) UpdateResourceHandler(c *gin.Context) {
 	// Map resourceKind to the corresponding resource name
 	resource, ok := resourceKindMapping[resourceKind]
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid resourceKind: %s", resourceKind)})
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid resourceKind: %s", resourceKind))
 		return
 	}
 
@@ -74,7 +81,7 @@ func (rh *ResourceHandler) UpdateResourceHandler(c *gin.Context) {
 	// Get data from the request
 	var requestBody interface{}
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -90,7 +97,7 @@ func (rh *ResourceHandler) UpdateResourceHandler(c *gin.Context) {
 	// Get the resource object
 	resourceObject, err := dynamicClient.Resource(resourceGroupVersion).Namespace(namespace).Get(context.TODO(), resourceName, metav1.GetOptions{})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to get %s resource: %v", resource, err)})
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to get %s resource: %v", resource, err))
 		return
 	}
 
@@ -106,7 +113,7 @@ func (rh *ResourceHandler) UpdateResourceHandler(c *gin.Context) {
 			// Convert requestBody to []byte
 			requestBodyBytes, err := json.Marshal(tmpRequestBody)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to marshal JSON: %v", err)})
+				writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to marshal JSON: %v", err))
 				return err
 			}
 			_, updateErr := dynamicClient.Resource(resourceGroupVersion).Namespace(namespace).Patch(context.TODO(),
@@ -129,7 +136,7 @@ func (rh *ResourceHandler) UpdateResourceHandler(c *gin.Context) {
 	})
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update %s resource: %v", resource, err)})
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to update %s resource: %v", resource, err))
 		return
 	}
 
@@ -141,7 +148,7 @@ func (rh *ResourceHandler) UpdateResourceHandler(c *gin.Context) {
 	// Delete the specified object
 	err = dynamicClient.Resource(toDeleteResourceGroupVersion).Namespace(namespace).Delete(context.TODO(), objName, metav1.DeleteOptions{})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete %s object: %v", objName, err)})
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to delete %s object: %v", objName, err))
 		return
 	}
 	// Return a success response
@@ -153,11 +160,9 @@ func (rh *ResourceHandler) ListRayServicesHandler(c *gin.Context) {
 	namespace := c.Param("namespace")
 	// Specify the label selector
 	labelSelector := config.GetInferenceServiceLabel()
-	rayServicesList, err := rh.RayClients.Clientset.RayV1().RayServices(namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
+	rayServicesList, err := rh.RayServiceCache.List(c.Request.Context(), namespace, labelSelector)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to list rayservices: %v", err)})
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to list rayservices: %v", err))
 		return
 	}
 
@@ -165,75 +170,183 @@ func (rh *ResourceHandler) ListRayServicesHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, rayServicesList)
 }
 
+// maxRayServiceNameLength leaves room for the "-service" suffix of the serve service name
+const maxRayServiceNameLength = validation.DNS1123LabelMaxLength - len("-service")
+
+// CreateRayServiceRequest is the body of CreateRayServiceHandler, the sizing fields fall back to the configured defaults
+type CreateRayServiceRequest struct {
+	Name          string `json:"name"`
+	LLMCheckpoint string `json:"llmCheckpoint"`
+	RayServiceSizing
+}
+
+// rayServiceParams are the values an inference rayservice is built from
+type rayServiceParams struct {
+	name           string
+	llmCheckpoint  string
+	image          string
+	llmPath        string
+	checkpointPath string
+	sizing         rayServiceSizing
+	// fields are the raw request fields, manifest templates may use fields the typed request does not know
+	fields map[string]interface{}
+}
+
+// validate checks the fields that do not need the API server
+func (r *CreateRayServiceRequest) validate() field.ErrorList {
+	var errs field.ErrorList
+	namePath := field.NewPath("name")
+	if r.Name == "" {
+		errs = append(errs, field.Required(namePath, ""))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(r.Name) {
+			errs = append(errs, field.Invalid(namePath, r.Name, msg))
+		}
+		if len(r.Name) > maxRayServiceNameLength {
+			errs = append(errs, field.TooLong(namePath, r.Name, maxRayServiceNameLength))
+		}
+	}
+	if r.LLMCheckpoint == "" {
+		errs = append(errs, field.Required(field.NewPath("llmCheckpoint"), ""))
+	}
+	return errs
+}
+
 // CreateRayServiceHandler 创建 Rayservice 对象的路由处理函数
+// ?dryRun=true 只在 API server 上做 dry-run，返回经过默认值填充和准入检查的清单而不落库；?format=yaml 以 YAML 返回
 func (rh *ResourceHandler) CreateRayServiceHandler(c *gin.Context) {
 	namespace := c.Param("namespace")
-
-	// 从请求体中获取创建 Rayservice 所需的数据
-	var requestBody map[string]interface{}
-	if err := c.ShouldBindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to parse request body: %v", err)})
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid 'dryRun': %v", err))
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "yaml" {
+		writeError(c, http.StatusBadRequest, "'format' must be one of json or yaml")
 		return
 	}
 
-	var image, llmPath, checkpointPath string
-	llmCheckpoint, err := rh.GetLlmCheckpoint(requestBody["llmCheckpoint"].(string), namespace)
+	// 从请求体中获取创建 Rayservice 所需的数据，原始字段保留给模板使用
+	var request CreateRayServiceRequest
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	var fields map[string]interface{}
+	if err := c.ShouldBindBodyWith(&fields, binding.JSON); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	defaultSizing, err := defaultRayServiceSizing()
 	if err != nil {
-		logging.ZLogger.Errorf("Failed to get LlmCheckpoint: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get LlmCheckpoint: %v", err)})
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
 	}
-	if llmCheckpoint.Spec.CheckpointImage != nil {
-		if llmCheckpoint.Spec.CheckpointImage.Name != nil {
-			image = *llmCheckpoint.Spec.CheckpointImage.Name
-		} else {
-			logging.ZLogger.Error("LlmCheckpoint missing CheckpointImage.Name")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "LlmCheckpoint missing CheckpointImage.Name"})
-			return
-		}
-		if llmCheckpoint.Spec.CheckpointImage.LLMPath != "" {
-			llmPath = llmCheckpoint.Spec.CheckpointImage.LLMPath
-		} else {
-			logging.ZLogger.Error("LlmCheckpoint missing CheckpointImage.LLMPath")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "LlmCheckpoint missing CheckpointImage.LLMPath"})
+
+	errs := request.validate()
+	sizing, sizingErrs := request.resolve(defaultSizing)
+	errs = append(errs, sizingErrs...)
+	params := rayServiceParams{name: request.Name, llmCheckpoint: request.LLMCheckpoint, sizing: sizing, fields: fields}
+	if request.LLMCheckpoint != "" {
+		checkpointErr, err := rh.resolveCheckpointImage(namespace, &params)
+		if err != nil {
+			logging.ZLogger.Errorf("Failed to get LlmCheckpoint: %v", err)
+			writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to get LlmCheckpoint: %v", err))
 			return
 		}
-		if llmCheckpoint.Spec.CheckpointImage.CheckPointPath != "" {
-			checkpointPath = llmCheckpoint.Spec.CheckpointImage.CheckPointPath
-		} else {
-			logging.ZLogger.Error("LlmCheckpoint missing CheckpointImage.CheckPointPath")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "LlmCheckpoint missing CheckpointImage.CheckPointPath"})
-			return
+		if checkpointErr != nil {
+			errs = append(errs, checkpointErr)
 		}
-	} else {
-		logging.ZLogger.Error("LlmCheckpoint missing CheckpointImage")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "LlmCheckpoint missing CheckpointImage"})
+	}
+	if len(errs) > 0 {
+		writeInvalid(c, rayServiceKind, request.Name, errs)
 		return
 	}
-	requestBody["image"] = image
-	requestBody["llmPath"] = llmPath
-	requestBody["checkpointPath"] = checkpointPath
+
 	// 创建 Rayservice 对象
-	rayService := rh.buildRayServiceObject(namespace, requestBody)
+	rayService, err := rh.newRayServiceObject(c.Request.Context(), namespace, params)
+	if err != nil {
+		logging.ZLogger.Errorf("Failed to build rayservice: %v", err)
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to build rayservice: %v", err))
+		return
+	}
 
 	// 使用 Rayservice 的 Client 进行创建
-	createdRayService, err := rh.RayClients.Clientset.RayV1().RayServices(namespace).Create(context.TODO(), rayService, metav1.CreateOptions{})
+	createOptions := metav1.CreateOptions{}
+	if dryRun {
+		createOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	createdRayService, err := rh.RayClients.Clientset.RayV1().RayServices(namespace).Create(context.TODO(), rayService, createOptions)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create rayservice: %v", err)})
+		writeError(c, rayServiceErrorStatus(err), fmt.Sprintf("Failed to create rayservice: %v", err))
 		return
 	}
+	if dryRun {
+		// 预览的清单可以直接 kubectl apply，去掉无关的 managedFields
+		createdRayService.ManagedFields = nil
+	}
 
 	// 返回创建成功的 Rayservice 对象
-	c.JSON(http.StatusOK, createdRayService)
+	writeRayService(c, createdRayService, format)
+}
+
+// writeRayService writes the rayservice as JSON or YAML, with its apiVersion and kind set so that the
+// manifest can be applied as is
+func writeRayService(c *gin.Context, rayService *rayv1.RayService, format string) {
+	rayService.APIVersion = rayv1.SchemeGroupVersion.String()
+	rayService.Kind = "RayService"
+	if format != "yaml" {
+		c.JSON(http.StatusOK, rayService)
+		return
+	}
+	manifest, err := yaml.Marshal(rayService)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to encode rayservice: %v", err))
+		return
+	}
+	c.Data(http.StatusOK, "application/yaml", manifest)
+}
+
+// resolveCheckpointImage fills the image and paths of the checkpoint into params. A missing or incomplete
+// checkpoint is reported as an invalid llmCheckpoint field, other errors are returned as is.
+func (rh *ResourceHandler) resolveCheckpointImage(namespace string, params *rayServiceParams) (*field.Error, error) {
+	checkpointPath := field.NewPath("llmCheckpoint")
+	llmCheckpoint, err := rh.GetLlmCheckpoint(params.llmCheckpoint, namespace)
+	if apierrors.IsNotFound(err) {
+		return field.NotFound(checkpointPath, params.llmCheckpoint), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpointImage := llmCheckpoint.Spec.CheckpointImage
+	switch {
+	case checkpointImage == nil:
+		return field.Invalid(checkpointPath, params.llmCheckpoint, "LLMCheckpoint has no checkpoint image"), nil
+	case checkpointImage.Name == nil || *checkpointImage.Name == "":
+		return field.Invalid(checkpointPath, params.llmCheckpoint, "LLMCheckpoint has no checkpoint image name"), nil
+	case checkpointImage.LLMPath == "":
+		return field.Invalid(checkpointPath, params.llmCheckpoint, "LLMCheckpoint has no llm path"), nil
+	case checkpointImage.CheckPointPath == "":
+		return field.Invalid(checkpointPath, params.llmCheckpoint, "LLMCheckpoint has no checkpoint path"), nil
+	}
+	params.image = *checkpointImage.Name
+	params.llmPath = checkpointImage.LLMPath
+	params.checkpointPath = checkpointImage.CheckPointPath
+	return nil, nil
 }
 
 // buildRayServiceObject 用于构建 Rayservice 对象
-func (rh *ResourceHandler) buildRayServiceObject(namespace string, data map[string]interface{}) *rayv1.RayService {
+func (rh *ResourceHandler) buildRayServiceObject(namespace string, params rayServiceParams) *rayv1.RayService {
 	// 根据你的数据结构构建 Rayservice 对象，以下是一个示例，你需要根据实际情况修改
-	replicas := int32(1)
-	gpu := float64(1)
+	var nodeSelector map[string]string
+	sizing := params.sizing
+	if sizing.gpusPerWorker > 0 {
+		nodeSelector = map[string]string{"nvidia.com/gpu": "present"}
+	}
 	rayService := &rayv1.RayService{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      data["name"].(string),
+			Name:      params.name,
 			Namespace: namespace,
 			Labels: func() map[string]string {
 				parts := strings.Split(config.GetInferenceServiceLabel(), "=")
@@ -243,7 +356,7 @@ func (rh *ResourceHandler) buildRayServiceObject(namespace string, data map[stri
 				return map[string]string{parts[0]: parts[1]}
 			}(),
 			Annotations: map[string]string{
-				"core.datatunerx.io/llmCheckpoint": data["llmCheckpoint"].(string),
+				annotationLLMCheckpoint: params.llmCheckpoint,
 			},
 		},
 		Spec: rayv1.RayServiceSpec{
@@ -255,7 +368,7 @@ func (rh *ResourceHandler) buildRayServiceObject(namespace string, data map[stri
 						Spec: v1.PodSpec{
 							Containers: []v1.Container{
 								{
-									Image: data["image"].(string),
+									Image: params.image,
 									Name:  "ray-head",
 									Ports: []v1.ContainerPort{
 										{
@@ -279,16 +392,7 @@ func (rh *ResourceHandler) buildRayServiceObject(namespace string, data map[stri
 											Protocol:      v1.ProtocolTCP,
 										},
 									},
-									Resources: v1.ResourceRequirements{
-										Limits: v1.ResourceList{
-											v1.ResourceCPU:    resource.MustParse("2000m"),
-											v1.ResourceMemory: resource.MustParse("8Gi"),
-										},
-										Requests: v1.ResourceList{
-											v1.ResourceCPU:    resource.MustParse("1000m"),
-											v1.ResourceMemory: resource.MustParse("4Gi"),
-										},
-									},
+									Resources: sizing.head,
 								},
 							},
 						},
@@ -298,25 +402,25 @@ func (rh *ResourceHandler) buildRayServiceObject(namespace string, data map[stri
 				WorkerGroupSpecs: []rayv1.WorkerGroupSpec{
 					{
 						GroupName:      "worker",
-						MaxReplicas:    &replicas,
-						MinReplicas:    &replicas,
+						MaxReplicas:    &sizing.workerMaxReplicas,
+						MinReplicas:    &sizing.workerMinReplicas,
 						RayStartParams: map[string]string{},
-						Replicas:       &replicas,
+						Replicas:       &sizing.workerReplicas,
 						Template: v1.PodTemplateSpec{
 							Spec: v1.PodSpec{
-								NodeSelector: map[string]string{"nvidia.com/gpu": "present"},
+								NodeSelector: nodeSelector,
 								Containers: []v1.Container{
 									{
-										Image: data["image"].(string),
+										Image: params.image,
 										Name:  "ray-worker",
 										Env: []v1.EnvVar{
 											{
 												Name:  "BASE_MODEL_DIR",
-												Value: data["llmPath"].(string),
+												Value: params.llmPath,
 											},
 											{
 												Name:  "CHECKPOINT_DIR",
-												Value: data["checkpointPath"].(string),
+												Value: params.checkpointPath,
 											},
 										},
 										Lifecycle: &v1.Lifecycle{
@@ -326,17 +430,7 @@ func (rh *ResourceHandler) buildRayServiceObject(namespace string, data map[stri
 												},
 											},
 										},
-										Resources: v1.ResourceRequirements{
-											Limits: v1.ResourceList{
-												v1.ResourceCPU:    resource.MustParse("8000m"),
-												v1.ResourceMemory: resource.MustParse("48Gi"),
-												"nvidia.com/gpu":  resource.MustParse("1"),
-											},
-											Requests: v1.ResourceList{
-												v1.ResourceCPU:    resource.MustParse("1000m"),
-												v1.ResourceMemory: resource.MustParse("48Gi"),
-											},
-										},
+										Resources: sizing.workerResources(),
 									},
 								},
 							},
@@ -348,9 +442,9 @@ func (rh *ResourceHandler) buildRayServiceObject(namespace string, data map[stri
 				ServeConfigSpecs: []rayv1.ServeConfigSpec{
 					{
 						Name:        "LlamaDeployment",
-						NumReplicas: &replicas,
+						NumReplicas: &sizing.serveReplicas,
 						RayActorOptions: rayv1.RayActorOptionSpec{
-							NumGpus: &gpu,
+							NumGpus: &sizing.gpusPerReplica,
 						},
 					},
 				},
@@ -359,7 +453,7 @@ func (rh *ResourceHandler) buildRayServiceObject(namespace string, data map[stri
 			},
 			ServeService: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:   data["name"].(string) + "-service",
+					Name:   params.name + "-service",
 					Labels: map[string]string{"app": "inference"},
 				},
 				Spec: v1.ServiceSpec{
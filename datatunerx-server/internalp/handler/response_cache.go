@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"datatunerx-server/pkg/cache"
+	"datatunerx-server/pkg/ray"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"github.com/gin-gonic/gin"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// responseCacheKey identifies a deterministic inference request
+type responseCacheKey struct {
+	Namespace     string                 `json:"namespace"`
+	Service       string                 `json:"service"`
+	LLMCheckpoint string                 `json:"llmCheckpoint"`
+	Messages      []InferenceBodyMessage `json:"messages"`
+	Parameters    GenerationParams       `json:"parameters"`
+}
+
+// cacheable reports whether the response to the body is deterministic: greedy decoding with a single choice
+func cacheable(requestBody InferenceBody) bool {
+	params := requestBody.GenerationParams
+	return !requestBody.Stream &&
+		params.Temperature != nil && *params.Temperature == 0 &&
+		(params.N == nil || *params.N == 1)
+}
+
+// responseCacheGroup groups the cached responses of a rayservice
+func responseCacheGroup(rayService *rayv1.RayService) string {
+	return rayService.Namespace + "/" + rayService.Name
+}
+
+func responseCacheKeyOf(rayService *rayv1.RayService, requestBody InferenceBody) (string, error) {
+	data, err := json.Marshal(responseCacheKey{
+		Namespace:     rayService.Namespace,
+		Service:       rayService.Name,
+		LLMCheckpoint: rayService.Annotations[annotationLLMCheckpoint],
+		Messages:      requestBody.Messages,
+		Parameters:    requestBody.GenerationParams,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// forwardCached serves deterministic requests from the response cache and forwards the rest.
+// With noCache set the cache is not read but the response is still stored.
+func (Ih *InferenceHandler) forwardCached(ctx context.Context, rayService *rayv1.RayService, targetURL string, requestBody InferenceBody, noCache bool) (InferenceProcessedResponse, bool, error) {
+	if Ih.ResponseCache == nil || !cacheable(requestBody) {
+		resp, err := forwardRequest(ctx, targetURL, requestBody)
+		return resp, false, err
+	}
+	key, err := responseCacheKeyOf(rayService, requestBody)
+	if err != nil {
+		resp, err := forwardRequest(ctx, targetURL, requestBody)
+		return resp, false, err
+	}
+	if !noCache {
+		if cached, ok := Ih.ResponseCache.Get(key); ok {
+			return cached.(InferenceProcessedResponse), true, nil
+		}
+	}
+	resp, err := forwardRequest(ctx, targetURL, requestBody)
+	if err != nil {
+		return resp, false, err
+	}
+	Ih.ResponseCache.Add(key, responseCacheGroup(rayService), resp)
+	return resp, false, nil
+}
+
+// noCacheRequested reports whether the client asked to bypass cached responses
+func noCacheRequested(c *gin.Context) bool {
+	return strings.Contains(strings.ToLower(c.GetHeader("Cache-Control")), "no-cache")
+}
+
+// invalidateResponseCacheOnCheckpointChange drops the cached responses of a rayservice
+// when its llmCheckpoint annotation changes or it is deleted
+func invalidateResponseCacheOnCheckpointChange(rayServiceCache *ray.RayServiceCache, responseCache *cache.LRU) {
+	err := rayServiceCache.AddEventHandler(k8scache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldRayService, ok := oldObj.(*rayv1.RayService)
+			if !ok {
+				return
+			}
+			newRayService, ok := newObj.(*rayv1.RayService)
+			if !ok {
+				return
+			}
+			if oldRayService.Annotations[annotationLLMCheckpoint] == newRayService.Annotations[annotationLLMCheckpoint] {
+				return
+			}
+			removed := responseCache.RemoveGroup(responseCacheGroup(newRayService))
+			logging.ZLogger.Infof("LLMCheckpoint of rayservice %s changed, dropped %d cached responses", responseCacheGroup(newRayService), removed)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(k8scache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if rayService, ok := obj.(*rayv1.RayService); ok {
+				responseCache.RemoveGroup(responseCacheGroup(rayService))
+			}
+		},
+	})
+	if err != nil {
+		logging.ZLogger.Warnf("Cached responses will not be invalidated on checkpoint changes: %v", err)
+	}
+}
+
+// ResponseCacheHandler returns the response cache counters
+func (Ih *InferenceHandler) ResponseCacheHandler(c *gin.Context) {
+	if Ih.ResponseCache == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "stats": Ih.ResponseCache.Stats()})
+}
@@ -0,0 +1,382 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"datatunerx-server/config"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+// InferenceRoute maps a logical inference name to several rayservices sharing its traffic by weight.
+// With Sticky set, requests of the same caller always reach the same backend while the weights are unchanged.
+type InferenceRoute struct {
+	Name     string         `json:"name"`
+	Backends []RouteBackend `json:"backends"`
+	Sticky   bool           `json:"sticky"`
+}
+
+// RouteBackend is a rayservice behind a route and its share of the traffic
+type RouteBackend struct {
+	Service string `json:"service"`
+	Weight  int    `json:"weight"`
+}
+
+// RouteBackendStatus is a backend with the traffic it actually received from this server
+type RouteBackendStatus struct {
+	RouteBackend
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	Share    float64 `json:"share"`
+}
+
+// InferenceRouteStatus is a route and the observed traffic split
+type InferenceRouteStatus struct {
+	Name     string               `json:"name"`
+	Sticky   bool                 `json:"sticky"`
+	Backends []RouteBackendStatus `json:"backends"`
+}
+
+// RouteNotFoundError is returned when no route of the name exists in the namespace
+type RouteNotFoundError struct {
+	Namespace string
+	Name      string
+}
+
+func (e *RouteNotFoundError) Error() string {
+	return fmt.Sprintf("inference route %s not found in namespace %s", e.Name, e.Namespace)
+}
+
+type routeBackendCounters struct {
+	requests int64
+	errors   int64
+}
+
+// routeCounters counts the requests sent to each backend of each route
+type routeCounters struct {
+	mu       sync.Mutex
+	counters map[string]*routeBackendCounters
+}
+
+func newRouteCounters() *routeCounters {
+	return &routeCounters{counters: make(map[string]*routeBackendCounters)}
+}
+
+func routeCounterKey(namespace, route, service string) string {
+	return namespace + "/" + route + "/" + service
+}
+
+func (rc *routeCounters) record(namespace, route, service string, failed bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	key := routeCounterKey(namespace, route, service)
+	counters, ok := rc.counters[key]
+	if !ok {
+		counters = &routeBackendCounters{}
+		rc.counters[key] = counters
+	}
+	counters.requests++
+	if failed {
+		counters.errors++
+	}
+}
+
+// status returns the route with the counters of its current backends
+func (rc *routeCounters) status(namespace string, route InferenceRoute) InferenceRouteStatus {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	status := InferenceRouteStatus{Name: route.Name, Sticky: route.Sticky, Backends: make([]RouteBackendStatus, 0, len(route.Backends))}
+	var total int64
+	for _, backend := range route.Backends {
+		backendStatus := RouteBackendStatus{RouteBackend: backend}
+		if counters, ok := rc.counters[routeCounterKey(namespace, route.Name, backend.Service)]; ok {
+			backendStatus.Requests = counters.requests
+			backendStatus.Errors = counters.errors
+		}
+		total += backendStatus.Requests
+		status.Backends = append(status.Backends, backendStatus)
+	}
+	if total > 0 {
+		for i := range status.Backends {
+			status.Backends[i].Share = float64(status.Backends[i].Requests) / float64(total)
+		}
+	}
+	return status
+}
+
+// Validate checks the route name and that it has at least one backend with a positive weight
+func (r *InferenceRoute) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("missing 'name' in the request body")
+	}
+	if errs := validation.IsConfigMapKey(r.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid route name %q: %s", r.Name, strings.Join(errs, "; "))
+	}
+	if len(r.Backends) == 0 {
+		return fmt.Errorf("missing 'backends' in the request body")
+	}
+	seen := make(map[string]struct{}, len(r.Backends))
+	total := 0
+	for i, backend := range r.Backends {
+		if backend.Service == "" {
+			return fmt.Errorf("backends[%d]: missing 'service'", i)
+		}
+		if _, ok := seen[backend.Service]; ok {
+			return fmt.Errorf("backends[%d]: duplicate service %q", i, backend.Service)
+		}
+		seen[backend.Service] = struct{}{}
+		if backend.Weight < 0 {
+			return fmt.Errorf("backends[%d]: weight must not be negative, got %d", i, backend.Weight)
+		}
+		total += backend.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one backend must have a positive weight")
+	}
+	return nil
+}
+
+// pick chooses a backend by weight, hashing the caller for sticky routes
+func (r *InferenceRoute) pick(caller string) string {
+	total := 0
+	for _, backend := range r.Backends {
+		total += backend.Weight
+	}
+	var point int
+	if r.Sticky && caller != anonymousCaller {
+		hash := fnv.New32a()
+		hash.Write([]byte(r.Name + "/" + caller))
+		point = int(hash.Sum32() % uint32(total))
+	} else {
+		point = rand.Intn(total)
+	}
+	for _, backend := range r.Backends {
+		if point < backend.Weight {
+			return backend.Service
+		}
+		point -= backend.Weight
+	}
+	return r.Backends[len(r.Backends)-1].Service
+}
+
+// RouteChatHandler serves a chat request on a route, sending it to one of its backends
+func (Ih *InferenceHandler) RouteChatHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	route, err := Ih.getRoute(c.Request.Context(), namespace, c.Param("routeName"))
+	if err != nil {
+		writeError(c, routeErrorStatus(err), err.Error())
+		return
+	}
+
+	service := route.pick(callerIdentity(c))
+	c.Header("X-Inference-Service", service)
+	Ih.chat(c, namespace, service)
+	// 只有 5xx（未就绪、熔断与上游错误）计为后端失败，请求错误与限流不算
+	Ih.RouteCounters.record(namespace, route.Name, service, c.Writer.Status() >= http.StatusInternalServerError)
+}
+
+// ListRoutesHandler lists the routes of the namespace with their observed traffic split
+func (Ih *InferenceHandler) ListRoutesHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	routes, err := Ih.listRoutes(c.Request.Context(), namespace)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to list routes: %v", err))
+		return
+	}
+	statuses := make([]InferenceRouteStatus, 0, len(routes))
+	for _, route := range routes {
+		statuses = append(statuses, Ih.RouteCounters.status(namespace, route))
+	}
+	c.JSON(http.StatusOK, statuses)
+}
+
+// GetRouteHandler returns a route with its observed traffic split
+func (Ih *InferenceHandler) GetRouteHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	route, err := Ih.getRoute(c.Request.Context(), namespace, c.Param("routeName"))
+	if err != nil {
+		writeError(c, routeErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, Ih.RouteCounters.status(namespace, *route))
+}
+
+// PutRouteHandler creates or replaces the route named in the path
+func (Ih *InferenceHandler) PutRouteHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	var route InferenceRoute
+	if err := c.ShouldBindJSON(&route); err != nil {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	if route.Name != "" && route.Name != c.Param("routeName") {
+		writeError(c, http.StatusBadRequest, "'name' does not match the route name in the path")
+		return
+	}
+	route.Name = c.Param("routeName")
+	if err := route.Validate(); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if status, err := Ih.checkRouteBackends(c.Request.Context(), namespace, route); err != nil {
+		writeError(c, status, err.Error())
+		return
+	}
+	data, err := json.Marshal(route)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = Ih.updateRoutes(c.Request.Context(), namespace, func(routes map[string]string) error {
+		routes[route.Name] = string(data)
+		return nil
+	})
+	if err != nil {
+		writeError(c, routeErrorStatus(err), fmt.Sprintf("Failed to save route: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, route)
+}
+
+// checkRouteBackends checks that every backend is an existing inference rayservice of the namespace,
+// returning the status code to respond with otherwise
+func (Ih *InferenceHandler) checkRouteBackends(ctx context.Context, namespace string, route InferenceRoute) (int, error) {
+	for i, backend := range route.Backends {
+		rayService, err := Ih.RayServiceCache.Get(ctx, namespace, backend.Service)
+		if apierrors.IsNotFound(err) || (err == nil && !isInferenceService(rayService)) {
+			return http.StatusUnprocessableEntity, fmt.Errorf("backends[%d]: inference service %s not found in namespace %s", i, backend.Service, namespace)
+		}
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("backends[%d]: failed to get rayservice %s: %v", i, backend.Service, err)
+		}
+	}
+	return 0, nil
+}
+
+// DeleteRouteHandler deletes a route
+func (Ih *InferenceHandler) DeleteRouteHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("routeName")
+	err := Ih.updateRoutes(c.Request.Context(), namespace, func(routes map[string]string) error {
+		if _, ok := routes[name]; !ok {
+			return &RouteNotFoundError{Namespace: namespace, Name: name}
+		}
+		delete(routes, name)
+		return nil
+	})
+	if err != nil {
+		writeError(c, routeErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Route %s deleted", name)})
+}
+
+// getRoute reads one route from the route ConfigMap of the namespace
+func (Ih *InferenceHandler) getRoute(ctx context.Context, namespace, name string) (*InferenceRoute, error) {
+	configMap, err := Ih.KubeClients.Clientset.CoreV1().ConfigMaps(namespace).Get(ctx, config.GetInferenceRouteConfigMap(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, &RouteNotFoundError{Namespace: namespace, Name: name}
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := configMap.Data[name]
+	if !ok {
+		return nil, &RouteNotFoundError{Namespace: namespace, Name: name}
+	}
+	return decodeRoute(name, data)
+}
+
+// listRoutes reads every valid route of the namespace sorted by name
+func (Ih *InferenceHandler) listRoutes(ctx context.Context, namespace string) ([]InferenceRoute, error) {
+	routes := []InferenceRoute{}
+	configMap, err := Ih.KubeClients.Clientset.CoreV1().ConfigMaps(namespace).Get(ctx, config.GetInferenceRouteConfigMap(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return routes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for name, data := range configMap.Data {
+		route, err := decodeRoute(name, data)
+		if err != nil {
+			continue
+		}
+		routes = append(routes, *route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Name < routes[j].Name
+	})
+	return routes, nil
+}
+
+// updateRoutes applies mutate to the data of the route ConfigMap, creating it if needed
+func (Ih *InferenceHandler) updateRoutes(ctx context.Context, namespace string, mutate func(routes map[string]string) error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMaps := Ih.KubeClients.Clientset.CoreV1().ConfigMaps(namespace)
+		configMap, err := configMaps.Get(ctx, config.GetInferenceRouteConfigMap(), metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      config.GetInferenceRouteConfigMap(),
+					Namespace: namespace,
+				},
+			}
+		} else if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		if err := mutate(configMap.Data); err != nil {
+			return err
+		}
+		if create {
+			_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), configMap.Name, err)
+			}
+			return err
+		}
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// decodeRoute decodes and validates a route stored under name
+func decodeRoute(name, data string) (*InferenceRoute, error) {
+	var route InferenceRoute
+	if err := json.Unmarshal([]byte(data), &route); err != nil {
+		return nil, fmt.Errorf("invalid route %s: %v", name, err)
+	}
+	route.Name = name
+	if err := route.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid route %s: %v", name, err)
+	}
+	return &route, nil
+}
+
+// routeErrorStatus maps route errors to a status code
+func routeErrorStatus(err error) int {
+	var notFound *RouteNotFoundError
+	if errors.As(err, &notFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRoutePickFollowsWeights(t *testing.T) {
+	route := InferenceRoute{
+		Name: "chat",
+		Backends: []RouteBackend{
+			{Service: "current", Weight: 90},
+			{Service: "candidate", Weight: 10},
+			{Service: "drained", Weight: 0},
+		},
+	}
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[route.pick(anonymousCaller)]++
+	}
+	if counts["drained"] != 0 {
+		t.Errorf("Expected no traffic to a zero weight backend, got %d", counts["drained"])
+	}
+	if counts["candidate"] < 700 || counts["candidate"] > 1300 {
+		t.Errorf("Expected about 10%% of the traffic on candidate, got %d of 10000", counts["candidate"])
+	}
+}
+
+func TestRoutePickSticky(t *testing.T) {
+	route := InferenceRoute{
+		Name:     "chat",
+		Sticky:   true,
+		Backends: []RouteBackend{{Service: "a", Weight: 50}, {Service: "b", Weight: 50}},
+	}
+	picked := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		caller := fmt.Sprintf("user-%d", i)
+		first := route.pick(caller)
+		for j := 0; j < 5; j++ {
+			if route.pick(caller) != first {
+				t.Fatalf("Expected caller %s to stick to %s", caller, first)
+			}
+		}
+		picked[first] = true
+	}
+	if len(picked) != 2 {
+		t.Errorf("Expected callers to be spread over both backends, got %v", picked)
+	}
+}
+
+func TestRouteValidate(t *testing.T) {
+	invalid := []InferenceRoute{
+		{Name: "chat"},
+		{Name: "chat", Backends: []RouteBackend{{Service: "a", Weight: 0}}},
+		{Name: "chat", Backends: []RouteBackend{{Service: "a", Weight: 1}, {Service: "a", Weight: 1}}},
+		{Name: "chat", Backends: []RouteBackend{{Service: "a", Weight: -1}, {Service: "b", Weight: 2}}},
+		{Name: "bad name", Backends: []RouteBackend{{Service: "a", Weight: 1}}},
+	}
+	for i, route := range invalid {
+		if err := route.Validate(); err == nil {
+			t.Errorf("Expected route %d to be invalid", i)
+		}
+	}
+}
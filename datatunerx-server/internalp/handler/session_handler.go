@@ -0,0 +1,545 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"datatunerx-server/config"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+const (
+	// maxWebSocketMessageSize bounds a single client message
+	maxWebSocketMessageSize = 1024 * 1024
+	webSocketWriteTimeout   = 10 * time.Second
+	webSocketPongTimeout    = 60 * time.Second
+	webSocketPingInterval   = webSocketPongTimeout / 2
+)
+
+var (
+	errSessionNotFound = errors.New("chat session not found")
+	errSessionBusy     = errors.New("a reply is already being generated in this chat session")
+)
+
+// ChatSession is the view of a chat session returned to clients
+type ChatSession struct {
+	ID        string                 `json:"id"`
+	Namespace string                 `json:"namespace"`
+	Service   string                 `json:"service"`
+	System    string                 `json:"system,omitempty"`
+	Messages  []InferenceBodyMessage `json:"messages"`
+	Turns     int                    `json:"turns"`
+	CreatedAt time.Time              `json:"createdAt"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+}
+
+type chatSession struct {
+	ChatSession
+	caller string
+	busy   bool
+}
+
+func (s *chatSession) view() ChatSession {
+	view := s.ChatSession
+	view.Messages = append([]InferenceBodyMessage{}, s.Messages...)
+	view.Turns = len(s.Messages) / 2
+	return view
+}
+
+// chatSessions keeps the conversation history of websocket chat sessions.
+// Sessions are kept in memory, are dropped after being idle for the configured timeout
+// and are lost when the server restarts.
+type chatSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*chatSession
+}
+
+func newChatSessions() *chatSessions {
+	return &chatSessions{sessions: make(map[string]*chatSession)}
+}
+
+func (cs *chatSessions) create(namespace, service, caller string) ChatSession {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.pruneLocked(time.Now())
+	now := time.Now()
+	session := &chatSession{
+		ChatSession: ChatSession{
+			ID:        rand.String(16),
+			Namespace: namespace,
+			Service:   service,
+			Messages:  []InferenceBodyMessage{},
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		caller: caller,
+	}
+	cs.sessions[session.ID] = session
+	return session.view()
+}
+
+// lookupLocked returns the session unless it belongs to another service or caller
+func (cs *chatSessions) lookupLocked(namespace, service, caller, id string) (*chatSession, bool) {
+	cs.pruneLocked(time.Now())
+	session, ok := cs.sessions[id]
+	if !ok || session.Namespace != namespace || session.Service != service || session.caller != caller {
+		return nil, false
+	}
+	return session, true
+}
+
+func (cs *chatSessions) get(namespace, service, caller, id string) (ChatSession, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	session, ok := cs.lookupLocked(namespace, service, caller, id)
+	if !ok {
+		return ChatSession{}, false
+	}
+	return session.view(), true
+}
+
+// reset clears the history of the session, its system prompt is kept
+func (cs *chatSessions) reset(namespace, service, caller, id string) (ChatSession, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	session, ok := cs.lookupLocked(namespace, service, caller, id)
+	if !ok {
+		return ChatSession{}, errSessionNotFound
+	}
+	if session.busy {
+		return ChatSession{}, errSessionBusy
+	}
+	session.Messages = []InferenceBodyMessage{}
+	session.UpdatedAt = time.Now()
+	return session.view(), nil
+}
+
+// begin marks the session busy and returns it; a system prompt, if given, replaces the session's one.
+// finish must be called once the reply is complete.
+func (cs *chatSessions) begin(namespace, service, caller, id, system string) (ChatSession, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	session, ok := cs.lookupLocked(namespace, service, caller, id)
+	if !ok {
+		return ChatSession{}, errSessionNotFound
+	}
+	if session.busy {
+		return ChatSession{}, errSessionBusy
+	}
+	session.busy = true
+	if system != "" {
+		session.System = system
+	}
+	return session.view(), nil
+}
+
+// finish releases the session, appending the turn to its history when the reply completed
+func (cs *chatSessions) finish(id string, turn []InferenceBodyMessage) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	session, ok := cs.sessions[id]
+	if !ok {
+		return
+	}
+	session.busy = false
+	session.UpdatedAt = time.Now()
+	if turn != nil {
+		session.Messages = trimHistory(append(session.Messages, turn...), config.GetInferenceSessionMaxTurns(), config.GetInferenceSessionMaxTokens())
+	}
+}
+
+// pruneLocked drops the sessions idle for longer than the configured timeout
+func (cs *chatSessions) pruneLocked(now time.Time) {
+	idleTimeout := config.GetInferenceSessionIdleTimeout()
+	if idleTimeout <= 0 {
+		return
+	}
+	for id, session := range cs.sessions {
+		if !session.busy && now.Sub(session.UpdatedAt) > idleTimeout {
+			delete(cs.sessions, id)
+		}
+	}
+}
+
+// estimateTokens roughly estimates the tokens of a text, about four characters per token
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// trimHistory drops the oldest user/assistant turns until at most maxTurns turns and maxTokens
+// estimated tokens remain. The latest turn is always kept, so that a new prompt is never dropped.
+// A zero bound disables it.
+func trimHistory(messages []InferenceBodyMessage, maxTurns, maxTokens int) []InferenceBodyMessage {
+	tokens := 0
+	for _, message := range messages {
+		tokens += estimateTokens(message.Content)
+	}
+	for len(messages) > 2 {
+		turns := (len(messages) + 1) / 2
+		if (maxTurns <= 0 || turns <= maxTurns) && (maxTokens <= 0 || tokens <= maxTokens) {
+			break
+		}
+		tokens -= estimateTokens(messages[0].Content) + estimateTokens(messages[1].Content)
+		messages = messages[2:]
+	}
+	return messages
+}
+
+// sessionRequestMessages returns the messages sent to the model for a new input: the system prompt first,
+// then the trimmed history alternating user and assistant and the input last. The inference service builds
+// the Llama-2 chat prompt from all of them.
+func sessionRequestMessages(session ChatSession, input string) []InferenceBodyMessage {
+	history := append(append([]InferenceBodyMessage{}, session.Messages...), InferenceBodyMessage{Role: "user", Content: input})
+	messages := trimHistory(history, config.GetInferenceSessionMaxTurns(), config.GetInferenceSessionMaxTokens())
+	if session.System != "" {
+		messages = append([]InferenceBodyMessage{{Role: "system", Content: session.System}}, messages...)
+	}
+	return messages
+}
+
+// ChatSessionMessage is a message sent by the client over the websocket:
+// "chat" asks for a reply to input, "cancel" stops the reply being generated and "reset" clears the history
+type ChatSessionMessage struct {
+	Type   string `json:"type"`
+	Input  string `json:"input,omitempty"`
+	System string `json:"system,omitempty"`
+	GenerationParams
+}
+
+// ChatSessionEvent is a message sent by the server over the websocket:
+// "session" carries the session, "delta" a chunk of the reply, "done" the usage of the completed reply,
+// "cancelled" acknowledges a cancelled reply and "error" reports a failure
+type ChatSessionEvent struct {
+	Type        string       `json:"type"`
+	Session     *ChatSession `json:"session,omitempty"`
+	Output      string       `json:"output,omitempty"`
+	TokenLength string       `json:"tokenLength,omitempty"`
+	ElapsedTime string       `json:"elapsedTime,omitempty"`
+	TokenPerSec string       `json:"tokenPerSec,omitempty"`
+	ResponseID  string       `json:"responseId,omitempty"`
+	Status      int          `json:"status,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// chatSessionConn serializes the writes to a websocket connection
+type chatSessionConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (sc *chatSessionConn) send(event ChatSessionEvent) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	return sc.conn.WriteJSON(event)
+}
+
+func (sc *chatSessionConn) ping() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout))
+}
+
+func (sc *chatSessionConn) sendError(status int, err error) error {
+	return sc.send(ChatSessionEvent{Type: "error", Status: status, Error: err.Error()})
+}
+
+// sendInferenceError reports an inference error with the status the HTTP endpoints would respond with
+func (sc *chatSessionConn) sendInferenceError(err error) error {
+	status, body := inferenceErrorResponse(err)
+	return sc.sendError(status, errors.New(fmt.Sprint(body["error"])))
+}
+
+// webSocketUpgrader checks the origin against the configured allowed origins
+func webSocketUpgrader() websocket.Upgrader {
+	upgrader := websocket.Upgrader{}
+	origins := config.GetInferenceWebSocketAllowedOrigins()
+	if len(origins) == 0 {
+		// 未配置时沿用默认的同源检查
+		return upgrader
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range origins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+		return false
+	}
+	return upgrader
+}
+
+// ChatSessionWebSocketHandler serves a chat session over a websocket.
+// The session given by the sessionId query parameter is resumed, otherwise a new one is created.
+func (Ih *InferenceHandler) ChatSessionWebSocketHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	rayServiceName := c.Param("serviceName")
+	caller := callerIdentity(c)
+
+	// 升级前检查 rayservice 与会话，错误以普通 HTTP 响应返回
+	if _, err := Ih.getServeService(c.Request.Context(), namespace, rayServiceName); err != nil {
+		writeInferenceError(c, err)
+		return
+	}
+	var session ChatSession
+	if sessionID := c.Query("sessionId"); sessionID != "" {
+		var ok bool
+		if session, ok = Ih.Sessions.get(namespace, rayServiceName, caller, sessionID); !ok {
+			writeError(c, http.StatusNotFound, fmt.Sprintf("Chat session %s not found", sessionID))
+			return
+		}
+	} else {
+		session = Ih.Sessions.create(namespace, rayServiceName, caller)
+	}
+
+	upgrader := webSocketUpgrader()
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade 已写入错误响应
+		logging.ZLogger.Warnf("Failed to upgrade chat session %s to websocket: %v", session.ID, err)
+		return
+	}
+	defer conn.Close()
+	sc := &chatSessionConn{conn: conn}
+	if err := sc.send(ChatSessionEvent{Type: "session", Session: &session}); err != nil {
+		return
+	}
+
+	conn.SetReadLimit(maxWebSocketMessageSize)
+	conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+	})
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		ticker := time.NewTicker(webSocketPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+				if err := sc.ping(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// 同一时间只生成一条回复，cancel 取消正在生成的回复
+	var generation sync.WaitGroup
+	var cancelGeneration context.CancelFunc
+	generating := func() bool {
+		return cancelGeneration != nil
+	}
+	done := make(chan struct{}, 1)
+	defer func() {
+		if generating() {
+			cancelGeneration()
+		}
+		generation.Wait()
+	}()
+
+	for {
+		var message ChatSessionMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logging.ZLogger.Infof("Chat session %s closed: %v", session.ID, err)
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+		// 回收已结束的生成
+		select {
+		case <-done:
+			cancelGeneration()
+			cancelGeneration = nil
+		default:
+		}
+
+		switch message.Type {
+		case "chat":
+			if generating() {
+				sc.sendError(http.StatusConflict, errSessionBusy)
+				continue
+			}
+			if message.Input == "" {
+				sc.sendError(http.StatusBadRequest, fmt.Errorf("missing 'input' in the chat message"))
+				continue
+			}
+			if err := message.GenerationParams.Validate(); err != nil {
+				sc.sendError(http.StatusBadRequest, fmt.Errorf("invalid generation parameter: %v", err))
+				continue
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancelGeneration = cancel
+			generation.Add(1)
+			go func() {
+				defer generation.Done()
+				Ih.generateSessionReply(ctx, sc, namespace, rayServiceName, caller, session.ID, message)
+				done <- struct{}{}
+			}()
+		case "cancel":
+			if generating() {
+				cancelGeneration()
+			}
+		case "reset":
+			reset, err := Ih.Sessions.reset(namespace, rayServiceName, caller, session.ID)
+			if err != nil {
+				sc.sendError(chatSessionErrorStatus(err), err)
+				continue
+			}
+			sc.send(ChatSessionEvent{Type: "session", Session: &reset})
+		default:
+			sc.sendError(http.StatusBadRequest, fmt.Errorf("unknown message type %q, must be one of chat, cancel or reset", message.Type))
+		}
+	}
+}
+
+// generateSessionReply streams the reply to the session's history and the new input,
+// the turn is only added to the history once the reply completed
+func (Ih *InferenceHandler) generateSessionReply(ctx context.Context, sc *chatSessionConn, namespace, rayServiceName, caller, sessionID string, message ChatSessionMessage) {
+	// 护栏只过滤新的输入与新的系统提示词，会话中的历史与系统提示词写入前已过滤
+	rails, err := Ih.guardrailsOf(ctx, namespace)
+	if err != nil {
+		sc.sendError(http.StatusInternalServerError, fmt.Errorf("failed to load guardrails: %v", err))
+		return
+	}
+	texts := []string{message.Input}
+	if message.System != "" {
+		texts = append(texts, message.System)
+	}
+	if texts, err = rails.filterInput(texts); err != nil {
+		sc.sendInferenceError(err)
+		return
+	}
+	input, system := texts[0], message.System
+	if system != "" {
+		system = texts[1]
+	}
+
+	session, err := Ih.Sessions.begin(namespace, rayServiceName, caller, sessionID, system)
+	if err != nil {
+		sc.sendError(chatSessionErrorStatus(err), err)
+		return
+	}
+	var turn []InferenceBodyMessage
+	defer func() {
+		Ih.Sessions.finish(sessionID, turn)
+	}()
+
+	rayService, release, err := Ih.admitService(ctx, namespace, rayServiceName)
+	if err != nil {
+		sc.sendInferenceError(err)
+		return
+	}
+	defer release()
+
+	transferBody := InferenceBody{
+		Model:            rayServiceName,
+		Messages:         sessionRequestMessages(session, input),
+		GenerationParams: message.GenerationParams.WithDefaults(generationDefaults(rayService)),
+	}
+	targetServiceURL := serveServiceURL(rayService.Spec.ServeService.Name, namespace, "/chat/completions")
+
+	var output []byte
+	usage, err := forwardStreamRequest(ctx, targetServiceURL, transferBody, func(delta string) {
+		if delta == "" {
+			return
+		}
+		output = append(output, delta...)
+		if !rails.filtersOutput() {
+			sc.send(ChatSessionEvent{Type: "delta", Output: delta})
+		}
+	})
+	if ctx.Err() != nil {
+		sc.send(ChatSessionEvent{Type: "cancelled"})
+		return
+	}
+	if err != nil {
+		sc.sendInferenceError(err)
+		return
+	}
+
+	Ih.recordUsage(rayService, caller, usage)
+	// 有输出护栏时回复整体过滤后一次发送
+	filtered := string(output)
+	if rails.filtersOutput() {
+		if filtered, err = rails.filterOutput(filtered); err != nil {
+			sc.sendInferenceError(err)
+			return
+		}
+		if filtered != "" {
+			sc.send(ChatSessionEvent{Type: "delta", Output: filtered})
+		}
+	}
+
+	turn = []InferenceBodyMessage{
+		{Role: "user", Content: input},
+		{Role: "assistant", Content: filtered},
+	}
+	resp := InferenceProcessedResponse{
+		Output:      filtered,
+		TokenLength: usage.TotalTokens,
+		ElapsedTime: usage.ElapsedTIme,
+		TokenPerSec: usage.TokenPerSec,
+		Usage:       usage,
+	}
+	Ih.capture(rayService, transferBody, resp)
+	responseID := newResponseID()
+	Ih.rememberExchange(responseID, rayService, caller, transferBody, filtered)
+	sc.send(ChatSessionEvent{
+		Type:        "done",
+		ResponseID:  responseID,
+		TokenLength: resp.TokenLength,
+		ElapsedTime: resp.ElapsedTime,
+		TokenPerSec: resp.TokenPerSec,
+	})
+}
+
+// GetChatSessionHandler returns a chat session and its history
+func (Ih *InferenceHandler) GetChatSessionHandler(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	session, ok := Ih.Sessions.get(c.Param("namespace"), c.Param("serviceName"), callerIdentity(c), sessionID)
+	if !ok {
+		writeError(c, http.StatusNotFound, fmt.Sprintf("Chat session %s not found", sessionID))
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// ResetChatSessionHandler clears the history of a chat session
+func (Ih *InferenceHandler) ResetChatSessionHandler(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	session, err := Ih.Sessions.reset(c.Param("namespace"), c.Param("serviceName"), callerIdentity(c), sessionID)
+	if err != nil {
+		writeError(c, chatSessionErrorStatus(err), fmt.Sprintf("Failed to reset chat session %s: %v", sessionID, err))
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+func chatSessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errSessionBusy):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
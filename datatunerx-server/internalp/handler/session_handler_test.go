@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func sessionTurns(n int, content string) []InferenceBodyMessage {
+	var messages []InferenceBodyMessage
+	for i := 0; i < n; i++ {
+		messages = append(messages,
+			InferenceBodyMessage{Role: "user", Content: content},
+			InferenceBodyMessage{Role: "assistant", Content: content})
+	}
+	return messages
+}
+
+func TestTrimHistoryKeepsMaxTurns(t *testing.T) {
+	history := append(sessionTurns(5, "hi"), InferenceBodyMessage{Role: "user", Content: "latest"})
+	trimmed := trimHistory(history, 3, 0)
+	if len(trimmed) != 5 {
+		t.Fatalf("Expected 2 turns and the new prompt, got %d messages", len(trimmed))
+	}
+	if trimmed[0].Role != "user" || trimmed[len(trimmed)-1].Content != "latest" {
+		t.Errorf("Expected the oldest turns to be dropped, got %+v", trimmed)
+	}
+}
+
+func TestTrimHistoryKeepsMaxTokens(t *testing.T) {
+	// 每条消息约 10 个 token
+	history := sessionTurns(4, strings.Repeat("a", 40))
+	if trimmed := trimHistory(history, 0, 45); len(trimmed) != 4 {
+		t.Errorf("Expected 2 turns within 45 tokens, got %d messages", len(trimmed))
+	}
+	if trimmed := trimHistory(history, 0, 1); len(trimmed) != 2 {
+		t.Errorf("Expected the latest turn to always be kept, got %d messages", len(trimmed))
+	}
+}
+
+func TestSessionRequestMessagesOrder(t *testing.T) {
+	session := ChatSession{System: "be brief", Messages: sessionTurns(2, "hi")}
+	messages := sessionRequestMessages(session, "latest")
+	if len(messages) != 6 {
+		t.Fatalf("Expected the system prompt, 2 turns and the input, got %d messages", len(messages))
+	}
+	if messages[0].Role != "system" || messages[0].Content != "be brief" {
+		t.Errorf("Expected the system prompt first, got %+v", messages[0])
+	}
+	for i, message := range messages[1:] {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		if message.Role != role {
+			t.Errorf("Expected message %d to be from %s, got %s", i+1, role, message.Role)
+		}
+	}
+	if last := messages[len(messages)-1]; last.Content != "latest" {
+		t.Errorf("Expected the input last, got %+v", last)
+	}
+	if len(session.Messages) != 4 {
+		t.Errorf("Expected the session history to be left unchanged, got %d messages", len(session.Messages))
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// writeError writes the error body shared by the handlers
+func writeError(c *gin.Context, code int, message string) {
+	c.JSON(code, errorStatus(code, message))
+}
+
+// writeInvalid writes a 422 Status listing every invalid field of the request for the named object
+func writeInvalid(c *gin.Context, kind schema.GroupKind, name string, errs field.ErrorList) {
+	c.JSON(http.StatusUnprocessableEntity, statusBody(apierrors.NewInvalid(kind, name, errs).ErrStatus))
+}
+
+// errorStatus returns the error body shared by the handlers, a Kubernetes Status with the reason derived
+// from the code. The message is repeated under "error", the body the handlers returned before.
+func errorStatus(code int, message string) gin.H {
+	return statusBody(metav1.Status{
+		Message: message,
+		Reason:  reasonForCode(code),
+		Code:    int32(code),
+	})
+}
+
+// statusBody turns a failure Status, e.g. one built by apierrors.NewInvalid, into an error body
+func statusBody(status metav1.Status) gin.H {
+	body := gin.H{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"status":     metav1.StatusFailure,
+		"message":    status.Message,
+		"code":       status.Code,
+		"error":      status.Message,
+	}
+	if status.Reason != metav1.StatusReasonUnknown {
+		body["reason"] = status.Reason
+	}
+	if status.Details != nil {
+		body["details"] = status.Details
+	}
+	return body
+}
+
+func reasonForCode(code int) metav1.StatusReason {
+	switch code {
+	case http.StatusBadRequest:
+		return metav1.StatusReasonBadRequest
+	case http.StatusUnauthorized:
+		return metav1.StatusReasonUnauthorized
+	case http.StatusForbidden:
+		return metav1.StatusReasonForbidden
+	case http.StatusNotFound:
+		return metav1.StatusReasonNotFound
+	case http.StatusMethodNotAllowed:
+		return metav1.StatusReasonMethodNotAllowed
+	case http.StatusNotAcceptable:
+		return metav1.StatusReasonNotAcceptable
+	case http.StatusConflict:
+		return metav1.StatusReasonConflict
+	case http.StatusGone:
+		return metav1.StatusReasonGone
+	case http.StatusRequestEntityTooLarge:
+		return metav1.StatusReasonRequestEntityTooLarge
+	case http.StatusUnsupportedMediaType:
+		return metav1.StatusReasonUnsupportedMediaType
+	case http.StatusUnprocessableEntity:
+		return metav1.StatusReasonInvalid
+	case http.StatusTooManyRequests:
+		return metav1.StatusReasonTooManyRequests
+	case http.StatusInternalServerError:
+		return metav1.StatusReasonInternalError
+	case http.StatusServiceUnavailable:
+		return metav1.StatusReasonServiceUnavailable
+	case http.StatusGatewayTimeout:
+		return metav1.StatusReasonTimeout
+	default:
+		return metav1.StatusReasonUnknown
+	}
+}
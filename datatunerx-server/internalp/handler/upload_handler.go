@@ -10,9 +10,10 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 
-	// "time"
-
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/metrics"
 	"datatunerx-server/pkg/s3"
 
 	"github.com/DataTunerX/utility-server/logging"
@@ -52,7 +53,7 @@ func (uh *UploadHandler) UploadFile(c *gin.Context) {
 	err := c.Request.ParseMultipartForm(0) // No limit on file size
 	if err != nil {
 		logging.ZLogger.Errorf("Failed to parse form: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+		writeError(c, http.StatusBadRequest, "Failed to parse form")
 		return
 	}
 
@@ -60,14 +61,14 @@ func (uh *UploadHandler) UploadFile(c *gin.Context) {
 	originalFile, header, err := c.Request.FormFile("file")
 	if err != nil {
 		logging.ZLogger.Errorf("Failed to get file from form: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file from form"})
+		writeError(c, http.StatusBadRequest, "Failed to get file from form")
 		return
 	}
 
 	// Ensure the file is not nil
 	if originalFile == nil {
 		logging.ZLogger.Errorf("File is nil")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File is nil"})
+		writeError(c, http.StatusBadRequest, "File is nil")
 		return
 	}
 
@@ -76,7 +77,7 @@ func (uh *UploadHandler) UploadFile(c *gin.Context) {
 	// defer file.Close()
 
 	// Set up the S3 bucket and object name
-	bucketName := "datatunerx"
+	bucketName := config.GetS3Bucket()
 	objectName := "uploads/" + header.Filename // Use original filename
 
 	// Check if file already exists in S3
@@ -99,7 +100,9 @@ func (uh *UploadHandler) UploadFile(c *gin.Context) {
 	// go uh.trackUploadProgress(ctx, progressCh, file)
 
 	// Upload the file to S3
+	start := time.Now()
 	s3URL, err := uh.uploadToS3WithProgress(bucketName, objectName, file, header, dataCh)
+	metrics.ObserveUpload(header.Size, time.Since(start), err)
 	if err != nil {
 		logging.ZLogger.Errorf("Failed to upload file to S3: %v", err)
 		// // Send an error event through SSE
@@ -111,7 +114,7 @@ func (uh *UploadHandler) UploadFile(c *gin.Context) {
 	formattedS3URL, err := formatS3URL(s3URL)
 	if err != nil {
 		logging.ZLogger.Errorf("Failed to format S3 URL: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to format S3 URL"})
+		writeError(c, http.StatusInternalServerError, "Failed to format S3 URL")
 		return
 	}
 
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/breaker"
+	"datatunerx-server/pkg/metrics"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// CircuitOpenError is returned without contacting the upstream while its circuit breaker is open
+type CircuitOpenError struct {
+	Upstream   string
+	RetryAfter int
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("upstream %s is failing, circuit breaker is open, retry after %ds", e.Upstream, e.RetryAfter)
+}
+
+// upstreamClient sends inference requests to serve services through a shared, pooled transport
+type upstreamClient struct {
+	// client bounds the whole exchange, streamClient only the wait for response headers
+	client       *http.Client
+	streamClient *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+	breakers     *breaker.Registry
+}
+
+var (
+	upstreamOnce sync.Once
+	upstream     *upstreamClient
+)
+
+// defaultUpstream returns the upstream client built from the configuration on first use
+func defaultUpstream() *upstreamClient {
+	upstreamOnce.Do(func() {
+		transport := &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   config.GetInferenceDialTimeout(),
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          config.GetInferenceMaxIdleConns(),
+			MaxIdleConnsPerHost:   config.GetInferenceMaxIdleConnsPerHost(),
+			IdleConnTimeout:       config.GetInferenceIdleConnTimeout(),
+			ResponseHeaderTimeout: config.GetInferenceResponseHeaderTimeout(),
+			ExpectContinueTimeout: time.Second,
+		}
+		upstream = &upstreamClient{
+			client:       &http.Client{Transport: transport, Timeout: config.GetInferenceRequestTimeout()},
+			streamClient: &http.Client{Transport: transport},
+			maxRetries:   config.GetInferenceMaxRetries(),
+			retryBackoff: config.GetInferenceRetryBackoff(),
+			breakers: breaker.NewRegistry(breaker.Settings{
+				FailureThreshold: config.GetInferenceBreakerFailureThreshold(),
+				OpenDuration:     config.GetInferenceBreakerOpenDuration(),
+			}),
+		}
+	})
+	return upstream
+}
+
+// post sends the JSON body to the target URL. Requests that could not connect are retried, since
+// they never reached the deployment; the response is left to the caller to close.
+func (u *upstreamClient) post(ctx context.Context, targetURL string, body []byte, stream bool) (*http.Response, error) {
+	name := upstreamName(targetURL)
+	done, retryAfter, ok := u.breakers.Allow(name)
+	if !ok {
+		return nil, &CircuitOpenError{Upstream: name, RetryAfter: int(math.Ceil(retryAfter.Seconds()))}
+	}
+
+	client := u.client
+	if stream {
+		client = u.streamClient
+	}
+	var resp *http.Response
+	var err error
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(body))
+		if err != nil {
+			done(breaker.Ignore)
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if stream {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+		resp, err = client.Do(req)
+		if err == nil || !isConnectError(err) || attempt >= u.maxRetries || ctx.Err() != nil {
+			break
+		}
+		logging.ZLogger.Warnf("Failed to connect to %s, retrying (%d/%d): %v", name, attempt+1, u.maxRetries, err)
+		select {
+		case <-time.After(u.retryBackoff * time.Duration(attempt+1)):
+		case <-ctx.Done():
+		}
+	}
+
+	code := 0
+	if err == nil {
+		code = resp.StatusCode
+	}
+	metrics.ObserveUpstream(name, code, time.Since(start))
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// 客户端断开或调用方的超时（如对比、批量推理的超时）不计入熔断，
+		// 只有客户端自身的超时与传输错误才算上游故障
+		done(breaker.Ignore)
+	case err != nil:
+		done(breaker.Failure)
+	case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+		done(breaker.Failure)
+	default:
+		done(breaker.Success)
+	}
+	return resp, err
+}
+
+// isConnectError reports whether the request failed before a connection was established
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// upstreamName names the breaker of a serve service, e.g. llama-serve-svc.default
+func upstreamName(targetURL string) string {
+	parsed, err := url.Parse(targetURL)
+	if err != nil {
+		return targetURL
+	}
+	return strings.TrimSuffix(parsed.Hostname(), ".svc.cluster.local")
+}
+
+// BreakersHandler returns the state of the circuit breaker of every upstream
+func (Ih *InferenceHandler) BreakersHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, defaultUpstream().breakers.States())
+}
+
+// ResetBreakerHandler closes the circuit breaker of an upstream
+func (Ih *InferenceHandler) ResetBreakerHandler(c *gin.Context) {
+	name := c.Param("name")
+	if !defaultUpstream().breakers.Reset(name) {
+		writeError(c, http.StatusNotFound, fmt.Sprintf("Circuit breaker %s not found", name))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Circuit breaker %s reset", name)})
+}
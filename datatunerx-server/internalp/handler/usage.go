@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"datatunerx-server/config"
+	"datatunerx-server/pkg/metrics"
+	"datatunerx-server/pkg/usage"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"github.com/gin-gonic/gin"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+)
+
+// anonymousCaller is recorded when the request carries no caller header
+const anonymousCaller = "anonymous"
+
+// QuotaExceededError is returned once a namespace has used up its monthly token quota
+type QuotaExceededError struct {
+	Namespace string
+	Quota     int64
+	Used      int64
+	ResetAt   time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("monthly token quota of namespace %s exceeded: %d of %d tokens used, resets at %s",
+		e.Namespace, e.Used, e.Quota, e.ResetAt.Format(time.RFC3339))
+}
+
+// UsageReport is the body returned by UsageReportHandler
+type UsageReport struct {
+	Namespace   string         `json:"namespace"`
+	Granularity string         `json:"granularity"`
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Buckets     []usage.Bucket `json:"buckets"`
+	Totals      usage.Counters `json:"totals"`
+	Quota       UsageQuota     `json:"quota"`
+}
+
+// UsageQuota is the monthly token quota of a namespace and how much of it is used
+type UsageQuota struct {
+	MonthlyTokenQuota int64     `json:"monthlyTokenQuota"`
+	UsedThisMonth     int64     `json:"usedThisMonth"`
+	ResetAt           time.Time `json:"resetAt"`
+}
+
+// callerIdentity returns the caller of the request from config.GetInferenceCallerHeader
+func callerIdentity(c *gin.Context) string {
+	if caller := strings.TrimSpace(c.GetHeader(config.GetInferenceCallerHeader())); caller != "" {
+		return caller
+	}
+	return anonymousCaller
+}
+
+// monthlyQuota returns the quota stored for the namespace, or the configured default
+func monthlyQuota(stored *int64) int64 {
+	if stored != nil {
+		return *stored
+	}
+	return config.GetInferenceMonthlyTokenQuota()
+}
+
+// checkQuota returns a QuotaExceededError once the namespace has used its monthly tokens.
+// Usage that cannot be read does not block requests.
+func (Ih *InferenceHandler) checkQuota(ctx context.Context, namespace string) error {
+	if Ih.Usage == nil {
+		return nil
+	}
+	now := time.Now()
+	used, stored, err := Ih.Usage.MonthlyTokens(ctx, namespace, now)
+	if err != nil {
+		logging.ZLogger.Errorf("Failed to read inference usage of namespace %s, skipping quota check: %v", namespace, err)
+		return nil
+	}
+	quota := monthlyQuota(stored)
+	if quota <= 0 || used < quota {
+		return nil
+	}
+	return &QuotaExceededError{
+		Namespace: namespace,
+		Quota:     quota,
+		Used:      used,
+		ResetAt:   usage.StartOfMonth(now).AddDate(0, 1, 0),
+	}
+}
+
+// recordUsage accounts the tokens reported by the deployment to the rayservice and caller
+func (Ih *InferenceHandler) recordUsage(rayService *rayv1.RayService, caller string, inferenceUsage InferenceUsage) {
+	tokens := toOpenAIUsage(inferenceUsage)
+	tokensPerSec, _ := strconv.ParseFloat(inferenceUsage.TokenPerSec, 64)
+	metrics.ObserveTokens(rayService.Namespace, rayService.Name, tokens.PromptTokens, tokens.CompletionTokens, tokens.TotalTokens, tokensPerSec)
+	if Ih.Usage == nil {
+		return
+	}
+	Ih.Usage.Record(rayService.Namespace, rayService.Name, caller, usage.Counters{
+		Requests:         1,
+		PromptTokens:     int64(tokens.PromptTokens),
+		CompletionTokens: int64(tokens.CompletionTokens),
+		TotalTokens:      int64(tokens.TotalTokens),
+	}, time.Now())
+}
+
+// UsageReportHandler returns the token usage of the namespace in hourly or daily buckets.
+// Query parameters: granularity (hour|day), from and to (RFC3339), service, caller and
+// groupBy (comma separated service,caller).
+func (Ih *InferenceHandler) UsageReportHandler(c *gin.Context) {
+	namespace := c.Param("namespace")
+	if Ih.Usage == nil {
+		writeError(c, http.StatusServiceUnavailable, "Usage accounting is not enabled")
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", usage.Day)
+	if granularity != usage.Hour && granularity != usage.Day {
+		writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid granularity %q, must be %s or %s", granularity, usage.Hour, usage.Day))
+		return
+	}
+	now := time.Now().UTC()
+	to := now
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid 'to': %v", err))
+			return
+		}
+		to = parsed.UTC()
+	}
+	from := to.AddDate(0, 0, -7)
+	if granularity == usage.Hour {
+		from = to.Add(-24 * time.Hour)
+	}
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid 'from': %v", err))
+			return
+		}
+		from = parsed.UTC()
+	}
+	if !from.Before(to) {
+		writeError(c, http.StatusBadRequest, "'from' must be before 'to'")
+		return
+	}
+	filter := usage.Filter{
+		From:    from,
+		To:      to,
+		Service: c.Query("service"),
+		Caller:  c.Query("caller"),
+	}
+	for _, field := range strings.Split(c.Query("groupBy"), ",") {
+		switch strings.TrimSpace(field) {
+		case "":
+		case "service":
+			filter.GroupByService = true
+		case "caller":
+			filter.GroupByCaller = true
+		default:
+			writeError(c, http.StatusBadRequest, fmt.Sprintf("Invalid groupBy field %q, must be service or caller", field))
+			return
+		}
+	}
+
+	namespaceUsage, err := Ih.Usage.Get(c.Request.Context(), namespace)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to read usage: %v", err))
+		return
+	}
+	source := namespaceUsage.Hourly
+	if granularity == usage.Day {
+		source = namespaceUsage.Daily
+		// 按天统计时包含 from 所在的整天
+		filter.From = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	} else {
+		filter.From = from.Truncate(time.Hour)
+	}
+	report := UsageReport{
+		Namespace:   namespace,
+		Granularity: granularity,
+		From:        filter.From,
+		To:          to,
+		Buckets:     usage.Aggregate(source, filter),
+	}
+	for _, bucket := range report.Buckets {
+		report.Totals.Add(bucket.Counters)
+	}
+
+	monthStart := usage.StartOfMonth(now)
+	for _, bucket := range namespaceUsage.Daily {
+		if !bucket.Start.Before(monthStart) {
+			report.Quota.UsedThisMonth += bucket.TotalTokens
+		}
+	}
+	report.Quota.MonthlyTokenQuota = monthlyQuota(namespaceUsage.MonthlyTokenQuota)
+	report.Quota.ResetAt = monthStart.AddDate(0, 1, 0)
+	c.JSON(http.StatusOK, report)
+}
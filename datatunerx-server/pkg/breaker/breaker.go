@@ -0,0 +1,169 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Breaker states
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half-open"
+)
+
+// Result of a call admitted by a breaker
+type Result int
+
+const (
+	Success Result = iota
+	Failure
+	// Ignore releases the call without counting it, e.g. when the caller went away
+	Ignore
+)
+
+// Settings configures every breaker of a Registry
+type Settings struct {
+	// FailureThreshold consecutive failures open the breaker, zero disables breaking
+	FailureThreshold int
+	// OpenDuration is how long an open breaker rejects calls before letting a probe through
+	OpenDuration time.Duration
+}
+
+// State is a snapshot of one breaker
+type State struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	TotalFailures       int64      `json:"totalFailures"`
+	Rejected            int64      `json:"rejected"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+}
+
+type breaker struct {
+	state               string
+	consecutiveFailures int
+	totalFailures       int64
+	rejected            int64
+	openedAt            time.Time
+	probing             bool
+}
+
+// Registry holds one circuit breaker per name, created on first use
+type Registry struct {
+	settings Settings
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry(settings Settings) *Registry {
+	return &Registry{settings: settings, breakers: make(map[string]*breaker)}
+}
+
+// Allow admits a call unless the breaker of name is open. On success done must be called
+// once with the result of the call; otherwise retryAfter tells when a probe will be let through.
+func (r *Registry) Allow(name string) (done func(Result), retryAfter time.Duration, ok bool) {
+	if r.settings.FailureThreshold <= 0 {
+		return func(Result) {}, 0, true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, exists := r.breakers[name]
+	if !exists {
+		b = &breaker{state: Closed}
+		r.breakers[name] = b
+	}
+
+	probe := false
+	switch b.state {
+	case Open:
+		if wait := time.Until(b.openedAt.Add(r.settings.OpenDuration)); wait > 0 {
+			b.rejected++
+			return nil, wait, false
+		}
+		b.state = HalfOpen
+		fallthrough
+	case HalfOpen:
+		// 半开状态只放行一个探测请求
+		if b.probing {
+			b.rejected++
+			return nil, r.settings.OpenDuration, false
+		}
+		b.probing = true
+		probe = true
+	}
+
+	var once sync.Once
+	return func(result Result) {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.recordLocked(b, probe, result)
+		})
+	}, 0, true
+}
+
+// recordLocked applies the result of a call to the breaker, r.mu must be held
+func (r *Registry) recordLocked(b *breaker, probe bool, result Result) {
+	if probe {
+		b.probing = false
+	}
+	switch result {
+	case Success:
+		b.consecutiveFailures = 0
+		if probe {
+			b.state = Closed
+		}
+	case Failure:
+		b.consecutiveFailures++
+		b.totalFailures++
+		if probe || (b.state == Closed && b.consecutiveFailures >= r.settings.FailureThreshold) {
+			b.state = Open
+			b.openedAt = time.Now()
+		}
+	}
+}
+
+// Reset closes the breaker of name, it returns false if there is no such breaker
+func (r *Registry) Reset(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[name]
+	if !ok {
+		return false
+	}
+	b.state = Closed
+	b.consecutiveFailures = 0
+	b.probing = false
+	return true
+}
+
+// States returns a snapshot of every breaker sorted by name
+func (r *Registry) States() []State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	states := make([]State, 0, len(r.breakers))
+	for name, b := range r.breakers {
+		state := State{
+			Name:                name,
+			State:               b.state,
+			ConsecutiveFailures: b.consecutiveFailures,
+			TotalFailures:       b.totalFailures,
+			Rejected:            b.rejected,
+		}
+		if b.state != Closed {
+			openedAt := b.openedAt
+			state.OpenedAt = &openedAt
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
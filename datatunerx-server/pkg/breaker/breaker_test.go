@@ -0,0 +1,64 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	registry := NewRegistry(Settings{FailureThreshold: 2, OpenDuration: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		done, _, ok := registry.Allow("llama")
+		if !ok {
+			t.Fatalf("Expected call %d to be allowed", i)
+		}
+		done(Failure)
+	}
+	if _, retryAfter, ok := registry.Allow("llama"); ok || retryAfter <= 0 {
+		t.Errorf("Expected the open breaker to reject with a retry-after, got ok=%v retryAfter=%v", ok, retryAfter)
+	}
+	if _, _, ok := registry.Allow("mistral"); !ok {
+		t.Error("Expected other breakers to be unaffected")
+	}
+
+	if !registry.Reset("llama") {
+		t.Fatal("Expected the breaker to exist")
+	}
+	if _, _, ok := registry.Allow("llama"); !ok {
+		t.Error("Expected the reset breaker to allow calls")
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	registry := NewRegistry(Settings{FailureThreshold: 1, OpenDuration: time.Millisecond})
+
+	done, _, _ := registry.Allow("llama")
+	done(Failure)
+	time.Sleep(5 * time.Millisecond)
+
+	probe, _, ok := registry.Allow("llama")
+	if !ok {
+		t.Fatal("Expected a probe after the open duration")
+	}
+	if _, _, ok := registry.Allow("llama"); ok {
+		t.Error("Expected only one probe while half-open")
+	}
+	probe(Success)
+
+	states := registry.States()
+	if len(states) != 1 || states[0].State != Closed {
+		t.Errorf("Expected the breaker to close after a successful probe, got %+v", states)
+	}
+}
+
+func TestBreakerIgnoresResult(t *testing.T) {
+	registry := NewRegistry(Settings{FailureThreshold: 1, OpenDuration: time.Hour})
+
+	done, _, _ := registry.Allow("llama")
+	done(Ignore)
+	done(Failure)
+	if _, _, ok := registry.Allow("llama"); !ok {
+		t.Error("Expected ignored calls not to open the breaker")
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a size and TTL bounded cache. Entries belong to a group so that all entries
+// derived from one object can be dropped together.
+type LRU struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	hits    int64
+	misses  int64
+}
+
+type entry struct {
+	key       string
+	group     string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// Stats is a snapshot of the cache counters
+type Stats struct {
+	Entries    int   `json:"entries"`
+	MaxEntries int   `json:"maxEntries"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+}
+
+// NewLRU creates a cache holding at most maxEntries entries for at most ttl each, a zero ttl never expires
+func NewLRU(maxEntries int, ttl time.Duration) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get returns the value of key unless it is missing or expired
+func (c *LRU) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	e := element.Value.(*entry)
+	if !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
+		c.removeLocked(element)
+		c.misses++
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	c.hits++
+	return e.value, true
+}
+
+// Add stores the value under key in group, evicting the least recently used entry when full
+func (c *LRU) Add(key, group string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = time.Now().Add(c.ttl)
+	}
+	if element, ok := c.entries[key]; ok {
+		e := element.Value.(*entry)
+		e.group = group
+		e.value = value
+		e.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, group: group, value: value, expiresAt: expiresAt})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+// RemoveGroup drops every entry of the group and returns how many were dropped
+func (c *LRU) RemoveGroup(group string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*entry).group == group {
+			c.removeLocked(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// Stats returns the number of entries and the hit and miss counters
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Entries: c.order.Len(), MaxEntries: c.maxEntries, Hits: c.hits, Misses: c.misses}
+}
+
+func (c *LRU) removeLocked(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(2, 0)
+	c.Add("a", "g", 1)
+	c.Add("b", "g", 2)
+	c.Get("a")
+	c.Add("c", "g", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	if value, ok := c.Get("a"); !ok || value.(int) != 1 {
+		t.Errorf("Expected a to be kept, got %v %v", value, ok)
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	c := NewLRU(10, time.Millisecond)
+	c.Add("a", "g", 1)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Expected a to expire")
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestLRURemoveGroup(t *testing.T) {
+	c := NewLRU(10, 0)
+	c.Add("a", "default/llama", 1)
+	c.Add("b", "default/llama", 2)
+	c.Add("c", "default/mistral", 3)
+
+	if removed := c.RemoveGroup("default/llama"); removed != 2 {
+		t.Errorf("Expected 2 entries removed, got %d", removed)
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("Expected entries of other groups to be kept")
+	}
+}
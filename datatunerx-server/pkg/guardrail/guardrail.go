@@ -0,0 +1,196 @@
+package guardrail
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Stage is the side of an inference a filter runs on
+type Stage string
+
+const (
+	Input  Stage = "input"
+	Output Stage = "output"
+)
+
+// Violation is returned when a filter blocks a request or a response
+type Violation struct {
+	Stage  Stage  `json:"stage"`
+	Filter string `json:"filter"`
+	Reason string `json:"reason"`
+	// Index is the message the violation was found in
+	Index int `json:"index"`
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s blocked by the %s guardrail: %s", v.Stage, v.Filter, v.Reason)
+}
+
+// Filter inspects the texts of one side of an inference. It returns the texts, possibly rewritten,
+// or a *Violation when they must be blocked.
+type Filter interface {
+	Name() string
+	Apply(texts []string) ([]string, error)
+}
+
+// Chain runs filters in order, each on the output of the previous one
+type Chain []Filter
+
+// Apply runs the chain on a copy of texts, violations are tagged with the stage
+func (c Chain) Apply(stage Stage, texts []string) ([]string, error) {
+	texts = append([]string{}, texts...)
+	for _, filter := range c {
+		var err error
+		texts, err = filter.Apply(texts)
+		if err != nil {
+			if violation, ok := err.(*Violation); ok {
+				violation.Stage = stage
+				violation.Filter = filter.Name()
+			}
+			return nil, err
+		}
+	}
+	return texts, nil
+}
+
+// Blocklist blocks texts containing one of the keywords, matched case-insensitively, or matching one of the patterns
+type Blocklist struct {
+	keywords []string
+	patterns []*regexp.Regexp
+}
+
+// NewBlocklist compiles the patterns of a blocklist
+func NewBlocklist(keywords, patterns []string) (*Blocklist, error) {
+	blocklist := &Blocklist{}
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			blocklist.keywords = append(blocklist.keywords, strings.ToLower(keyword))
+		}
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocklist pattern %q: %v", pattern, err)
+		}
+		blocklist.patterns = append(blocklist.patterns, re)
+	}
+	return blocklist, nil
+}
+
+func (b *Blocklist) Name() string {
+	return "blocklist"
+}
+
+func (b *Blocklist) Apply(texts []string) ([]string, error) {
+	for i, text := range texts {
+		lower := strings.ToLower(text)
+		for _, keyword := range b.keywords {
+			if strings.Contains(lower, keyword) {
+				return nil, &Violation{Index: i, Reason: fmt.Sprintf("contains the blocked keyword %q", keyword)}
+			}
+		}
+		for _, re := range b.patterns {
+			if re.MatchString(text) {
+				return nil, &Violation{Index: i, Reason: fmt.Sprintf("matches the blocked pattern %q", re.String())}
+			}
+		}
+	}
+	return texts, nil
+}
+
+// MaxLength blocks texts longer than MaxChars characters in total
+type MaxLength struct {
+	MaxChars int
+}
+
+func (m *MaxLength) Name() string {
+	return "maxLength"
+}
+
+func (m *MaxLength) Apply(texts []string) ([]string, error) {
+	length := 0
+	for _, text := range texts {
+		length += utf8.RuneCountInString(text)
+	}
+	if length > m.MaxChars {
+		return nil, &Violation{Index: len(texts) - 1, Reason: fmt.Sprintf("%d characters exceed the limit of %d", length, m.MaxChars)}
+	}
+	return texts, nil
+}
+
+// PII kinds detected by PIIFilter
+const (
+	PIIEmail    = "email"
+	PIIPhone    = "phone"
+	PIIIDNumber = "idNumber"
+)
+
+// PII actions of PIIFilter
+const (
+	PIIActionMask  = "mask"
+	PIIActionBlock = "block"
+)
+
+type piiDetector struct {
+	kind        string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// 身份证号先于手机号匹配，避免被部分识别为手机号
+var piiDetectors = []piiDetector{
+	{PIIEmail, regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{PIIIDNumber, regexp.MustCompile(`\b[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`), "[ID_NUMBER]"},
+	{PIIPhone, regexp.MustCompile(`(?:\+86[- ]?|\b86[- ]?|\b)1[3-9]\d{9}\b|\+\d{1,3}[- ]?\(?\d{1,4}\)?(?:[- ]?\d{2,4}){2,3}\b`), "[PHONE]"},
+}
+
+// PIIFilter masks or blocks emails, phone numbers and ID numbers
+type PIIFilter struct {
+	kinds  map[string]bool
+	action string
+}
+
+// NewPIIFilter creates a filter for the given kinds, all kinds when empty. The action is mask or block, mask when empty.
+func NewPIIFilter(kinds []string, action string) (*PIIFilter, error) {
+	filter := &PIIFilter{kinds: make(map[string]bool), action: action}
+	if filter.action == "" {
+		filter.action = PIIActionMask
+	}
+	if filter.action != PIIActionMask && filter.action != PIIActionBlock {
+		return nil, fmt.Errorf("invalid pii action %q, must be one of %s or %s", action, PIIActionMask, PIIActionBlock)
+	}
+	for _, kind := range kinds {
+		if kind != PIIEmail && kind != PIIPhone && kind != PIIIDNumber {
+			return nil, fmt.Errorf("invalid pii kind %q, must be one of %s, %s or %s", kind, PIIEmail, PIIPhone, PIIIDNumber)
+		}
+		filter.kinds[kind] = true
+	}
+	if len(kinds) == 0 {
+		for _, detector := range piiDetectors {
+			filter.kinds[detector.kind] = true
+		}
+	}
+	return filter, nil
+}
+
+func (p *PIIFilter) Name() string {
+	return "pii"
+}
+
+func (p *PIIFilter) Apply(texts []string) ([]string, error) {
+	for i, text := range texts {
+		for _, detector := range piiDetectors {
+			if !p.kinds[detector.kind] || !detector.pattern.MatchString(text) {
+				continue
+			}
+			if p.action == PIIActionBlock {
+				return nil, &Violation{Index: i, Reason: fmt.Sprintf("contains a %s", detector.kind)}
+			}
+			text = detector.pattern.ReplaceAllString(text, detector.replacement)
+		}
+		texts[i] = text
+	}
+	return texts, nil
+}
@@ -0,0 +1,49 @@
+package guardrail
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPIIFilterMasks(t *testing.T) {
+	filter, err := NewPIIFilter(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts, err := filter.Apply([]string{
+		"mail alice@example.com or call 13812345678",
+		"身份证 11010519491231002X，电话 +86 139-0000-0000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts[0] != "mail [EMAIL] or call [PHONE]" {
+		t.Errorf("Unexpected masked text: %s", texts[0])
+	}
+	if texts[1] != "身份证 [ID_NUMBER]，电话 [PHONE]" {
+		t.Errorf("Unexpected masked text: %s", texts[1])
+	}
+}
+
+func TestChainReportsViolation(t *testing.T) {
+	blocklist, err := NewBlocklist([]string{"Secret"}, []string{`(?i)drop\s+table`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := Chain{&MaxLength{MaxChars: 100}, blocklist}
+
+	if _, err := chain.Apply(Input, []string{"hello", "please DROP  TABLE users"}); err == nil {
+		t.Fatal("Expected the pattern to be blocked")
+	} else {
+		var violation *Violation
+		if !errors.As(err, &violation) || violation.Stage != Input || violation.Filter != "blocklist" || violation.Index != 1 {
+			t.Errorf("Unexpected violation: %+v", err)
+		}
+	}
+	if _, err := chain.Apply(Output, []string{"the secret is out"}); err == nil {
+		t.Error("Expected keywords to match case-insensitively")
+	}
+	if _, err := (Chain{&MaxLength{MaxChars: 5}}).Apply(Input, []string{"abc", "def"}); err == nil {
+		t.Error("Expected the total length to be limited")
+	}
+}
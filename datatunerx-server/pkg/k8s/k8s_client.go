@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 
+	"datatunerx-server/pkg/metrics"
+
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -38,6 +40,9 @@ func InitKubeClient() KubernetesClients {
 		fmt.Printf("Using kubeconfig file: %s\n", kubeconfig)
 	}
 
+	// 统计 Kubernetes API 调用错误
+	config.Wrap(metrics.KubernetesAPITransport)
+
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		panic(err.Error())
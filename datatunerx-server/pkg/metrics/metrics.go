@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace prefixes every metric exposed by the server
+const namespace = "datatunerx_server"
+
+// ResultError labels calls that failed without a status code
+const ResultError = "error"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests served, by route and status code.",
+		Buckets:   []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"method", "route", "code"})
+
+	inferenceUpstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "inference_upstream_duration_seconds",
+		Help:      "Time until the inference deployment responded with headers, retries included, by serve service and status code.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"upstream", "code"})
+
+	inferenceTokens = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "inference_tokens",
+		Help:      "Tokens used per inference request, by rayservice and kind.",
+		Buckets:   prometheus.ExponentialBuckets(16, 2, 10),
+	}, []string{"namespace", "service", "kind"})
+
+	inferenceTokensPerSecond = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "inference_tokens_per_second",
+		Help:      "Generation speed reported by the deployment, by rayservice.",
+		Buckets:   []float64{1, 5, 10, 20, 40, 60, 80, 100, 150, 200, 400},
+	}, []string{"namespace", "service"})
+
+	uploadBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes",
+		Help:      "Size of the files uploaded to S3, by result.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 12),
+	}, []string{"result"})
+
+	uploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time spent uploading files to S3, by result.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"result"})
+
+	prometheusQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "prometheus_query_duration_seconds",
+		Help:      "Latency of the Prometheus queries made for finetune metrics, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	kubernetesAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kubernetes_api_errors_total",
+		Help:      "Kubernetes API calls that failed or returned an error status other than 404 and 409, by method and status code.",
+	}, []string{"method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequests,
+		httpRequestDuration,
+		inferenceUpstreamDuration,
+		inferenceTokens,
+		inferenceTokensPerSecond,
+		uploadBytes,
+		uploadDuration,
+		prometheusQueryDuration,
+		kubernetesAPIErrors,
+	)
+}
+
+// Middleware records the count and latency of the requests served by the router.
+// Requests are labelled by route template, requests matching no route as "unmatched".
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		code := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, code).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, code).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveUpstream records the latency of a call to an inference deployment, a zero code marks a failed call
+func ObserveUpstream(upstream string, code int, duration time.Duration) {
+	inferenceUpstreamDuration.WithLabelValues(upstream, codeLabel(code)).Observe(duration.Seconds())
+}
+
+// ObserveTokens records the tokens used by an inference request and the generation speed, when reported
+func ObserveTokens(namespace, service string, promptTokens, completionTokens, totalTokens int, tokensPerSecond float64) {
+	inferenceTokens.WithLabelValues(namespace, service, "prompt").Observe(float64(promptTokens))
+	inferenceTokens.WithLabelValues(namespace, service, "completion").Observe(float64(completionTokens))
+	inferenceTokens.WithLabelValues(namespace, service, "total").Observe(float64(totalTokens))
+	if tokensPerSecond > 0 {
+		inferenceTokensPerSecond.WithLabelValues(namespace, service).Observe(tokensPerSecond)
+	}
+}
+
+// ObserveUpload records the size and duration of an upload to S3
+func ObserveUpload(size int64, duration time.Duration, err error) {
+	result := resultLabel(err)
+	uploadBytes.WithLabelValues(result).Observe(float64(size))
+	uploadDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// ObservePrometheusQuery records the latency of a Prometheus query
+func ObservePrometheusQuery(duration time.Duration, err error) {
+	prometheusQueryDuration.WithLabelValues(resultLabel(err)).Observe(duration.Seconds())
+}
+
+// KubernetesAPITransport wraps the transport of a Kubernetes client, counting the calls that fail or return
+// a status of 400 and above. Not found and conflict are left out, the handlers expect them in normal operation,
+// e.g. for missing ConfigMaps and optimistic concurrency retries. It is meant for rest.Config.Wrap.
+func KubernetesAPITransport(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			kubernetesAPIErrors.WithLabelValues(req.Method, ResultError).Inc()
+			return resp, err
+		}
+		if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusConflict {
+			kubernetesAPIErrors.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+		}
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func codeLabel(code int) string {
+	if code == 0 {
+		return ResultError
+	}
+	return strconv.Itoa(code)
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return "success"
+}
@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// inFlightRetryAfter is suggested to callers rejected by an in-flight cap
+const inFlightRetryAfter = time.Second
+
+// Limits configures a token bucket and an in-flight cap, a zero value disables each
+type Limits struct {
+	RPS         float64 `json:"rps"`
+	Burst       int     `json:"burst"`
+	MaxInFlight int     `json:"maxInFlight"`
+}
+
+// Key names a limiter and the limits it should currently enforce
+type Key struct {
+	Name   string
+	Limits Limits
+}
+
+// State is a snapshot of one limiter
+type State struct {
+	Name        string  `json:"name"`
+	RPS         float64 `json:"rps"`
+	Burst       int     `json:"burst"`
+	MaxInFlight int     `json:"maxInFlight"`
+	InFlight    int     `json:"inFlight"`
+	Tokens      float64 `json:"tokens"`
+	Rejected    int64   `json:"rejected"`
+}
+
+type limiter struct {
+	limits   Limits
+	bucket   *rate.Limiter
+	inFlight int
+	rejected int64
+}
+
+// Registry holds one limiter per key, created on first use
+type Registry struct {
+	mu       sync.Mutex
+	limiters map[string]*limiter
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{limiters: make(map[string]*limiter)}
+}
+
+// Acquire takes a token and an in-flight slot from every key, or from none of them.
+// On success the returned release func must be called once the request is done;
+// otherwise retryAfter suggests when to try again.
+func (r *Registry) Acquire(keys ...Key) (release func(), retryAfter time.Duration, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	limiters := make([]*limiter, 0, len(keys))
+	for _, key := range keys {
+		l := r.limiterLocked(key)
+		if l.limits.MaxInFlight > 0 && l.inFlight >= l.limits.MaxInFlight {
+			l.rejected++
+			return nil, inFlightRetryAfter, false
+		}
+		limiters = append(limiters, l)
+	}
+
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	for _, l := range limiters {
+		if l.bucket == nil {
+			continue
+		}
+		reservation := l.bucket.ReserveN(now, 1)
+		if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+			delay := reservation.DelayFrom(now)
+			reservation.CancelAt(now)
+			for _, taken := range reservations {
+				taken.CancelAt(now)
+			}
+			l.rejected++
+			if delay <= 0 || delay == rate.InfDuration {
+				delay = inFlightRetryAfter
+			}
+			return nil, delay, false
+		}
+		reservations = append(reservations, reservation)
+	}
+
+	for _, l := range limiters {
+		l.inFlight++
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for _, l := range limiters {
+				l.inFlight--
+			}
+		})
+	}, 0, true
+}
+
+// limiterLocked returns the limiter for the key, applying limit changes, r.mu must be held
+func (r *Registry) limiterLocked(key Key) *limiter {
+	l, ok := r.limiters[key.Name]
+	if !ok {
+		l = &limiter{}
+		r.limiters[key.Name] = l
+	}
+	if l.limits != key.Limits {
+		l.limits = key.Limits
+		switch {
+		case key.Limits.RPS <= 0:
+			l.bucket = nil
+		case l.bucket == nil:
+			l.bucket = rate.NewLimiter(rate.Limit(key.Limits.RPS), burst(key.Limits))
+		default:
+			l.bucket.SetLimit(rate.Limit(key.Limits.RPS))
+			l.bucket.SetBurst(burst(key.Limits))
+		}
+	}
+	return l
+}
+
+// burst defaults to one request per second of rate, and at least one
+func burst(limits Limits) int {
+	if limits.Burst > 0 {
+		return limits.Burst
+	}
+	if limits.RPS < 1 {
+		return 1
+	}
+	return int(limits.RPS)
+}
+
+// States returns a snapshot of every limiter sorted by name
+func (r *Registry) States() []State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	states := make([]State, 0, len(r.limiters))
+	for name, l := range r.limiters {
+		state := State{
+			Name:        name,
+			RPS:         l.limits.RPS,
+			Burst:       l.limits.Burst,
+			MaxInFlight: l.limits.MaxInFlight,
+			InFlight:    l.inFlight,
+			Rejected:    l.rejected,
+		}
+		if l.bucket != nil {
+			state.Burst = l.bucket.Burst()
+			state.Tokens = l.bucket.TokensAt(now)
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"testing"
+)
+
+func TestAcquireMaxInFlight(t *testing.T) {
+	registry := NewRegistry()
+	key := Key{Name: "service/default/llama", Limits: Limits{MaxInFlight: 1}}
+
+	release, _, ok := registry.Acquire(key)
+	if !ok {
+		t.Fatal("Expected the first request to be admitted")
+	}
+	if _, retryAfter, ok := registry.Acquire(key); ok || retryAfter <= 0 {
+		t.Errorf("Expected the second request to be rejected with a retry-after, got ok=%v retryAfter=%v", ok, retryAfter)
+	}
+
+	release()
+	release()
+	if _, _, ok := registry.Acquire(key); !ok {
+		t.Error("Expected a request to be admitted after release")
+	}
+}
+
+func TestAcquireRateLimit(t *testing.T) {
+	registry := NewRegistry()
+	key := Key{Name: "namespace/default", Limits: Limits{RPS: 1, Burst: 2}}
+
+	for i := 0; i < 2; i++ {
+		release, _, ok := registry.Acquire(key)
+		if !ok {
+			t.Fatalf("Expected request %d to be admitted within the burst", i)
+		}
+		release()
+	}
+	if _, retryAfter, ok := registry.Acquire(key); ok || retryAfter <= 0 {
+		t.Errorf("Expected the request beyond the burst to be rejected with a retry-after, got ok=%v retryAfter=%v", ok, retryAfter)
+	}
+}
+
+func TestAcquireAllOrNothing(t *testing.T) {
+	registry := NewRegistry()
+	namespace := Key{Name: "namespace/default", Limits: Limits{RPS: 1, Burst: 1}}
+	service := Key{Name: "service/default/llama", Limits: Limits{RPS: 1, Burst: 1}}
+
+	if _, _, ok := registry.Acquire(service); !ok {
+		t.Fatal("Expected the service token to be admitted")
+	}
+	if _, _, ok := registry.Acquire(namespace, service); ok {
+		t.Fatal("Expected the request to be rejected by the service limit")
+	}
+
+	// 被拒绝的请求不应消耗命名空间的令牌
+	if _, _, ok := registry.Acquire(namespace); !ok {
+		t.Error("Expected the namespace token to be untouched by the rejected request")
+	}
+}
+
+func TestStatesReflectLimitChanges(t *testing.T) {
+	registry := NewRegistry()
+	release, _, _ := registry.Acquire(Key{Name: "a", Limits: Limits{RPS: 5}})
+	defer release()
+	registry.Acquire(Key{Name: "a", Limits: Limits{RPS: 10, Burst: 20}})
+
+	states := registry.States()
+	if len(states) != 1 {
+		t.Fatalf("Expected 1 limiter, got %d", len(states))
+	}
+	if states[0].RPS != 10 || states[0].Burst != 20 || states[0].InFlight != 2 {
+		t.Errorf("Unexpected state: %+v", states[0])
+	}
+}
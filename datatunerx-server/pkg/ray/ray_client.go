@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 
+	"datatunerx-server/pkg/metrics"
+
 	"github.com/ray-project/kuberay/ray-operator/pkg/client/clientset/versioned"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -36,6 +38,9 @@ func InitRayClient() (RayClient, error) {
 		fmt.Printf("Using kubeconfig file: %s\n", kubeconfig)
 	}
 
+	// 统计 Kubernetes API 调用错误
+	config.Wrap(metrics.KubernetesAPITransport)
+
 	clientset, err := versioned.NewForConfig(config)
 	if err != nil {
 		return RayClient{}, fmt.Errorf("failed to create Ray V1 client: %v", err)
@@ -0,0 +1,117 @@
+package ray
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/DataTunerX/utility-server/logging"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	"github.com/ray-project/kuberay/ray-operator/pkg/client/informers/externalversions"
+	listers "github.com/ray-project/kuberay/ray-operator/pkg/client/listers/ray/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// RayServiceCache serves rayservice reads from a shared informer scoped to one namespace (or all
+// namespaces when empty). Until the informer has synced, and on cache misses, it reads from the API server.
+// Objects returned from the cache are shared and must not be modified.
+type RayServiceCache struct {
+	client    RayClient
+	namespace string
+	factory   externalversions.SharedInformerFactory
+	informer  cache.SharedIndexInformer
+	lister    listers.RayServiceLister
+}
+
+// NewRayServiceCache creates the informer, call Start to begin watching
+func NewRayServiceCache(client RayClient, namespace string, resync time.Duration) *RayServiceCache {
+	rc := &RayServiceCache{
+		client:    client,
+		namespace: namespace,
+	}
+	if client.Clientset == nil {
+		return rc
+	}
+	rc.factory = externalversions.NewSharedInformerFactoryWithOptions(client.Clientset, resync, externalversions.WithNamespace(namespace))
+	rayServiceInformer := rc.factory.Ray().V1().RayServices()
+	rc.informer = rayServiceInformer.Informer()
+	rc.lister = rayServiceInformer.Lister()
+	return rc
+}
+
+// Start runs the informer until stopCh is closed
+func (rc *RayServiceCache) Start(stopCh <-chan struct{}) {
+	if rc.factory == nil {
+		return
+	}
+	rc.factory.Start(stopCh)
+	go func() {
+		if !cache.WaitForCacheSync(stopCh, rc.informer.HasSynced) {
+			logging.ZLogger.Warn("RayService cache stopped before it synced")
+			return
+		}
+		logging.ZLogger.Info("RayService cache synced")
+	}()
+}
+
+// HasSynced reports whether the informer has completed its initial list
+func (rc *RayServiceCache) HasSynced() bool {
+	return rc.informer != nil && rc.informer.HasSynced()
+}
+
+// AddEventHandler registers a handler for rayservice add, update and delete events
+func (rc *RayServiceCache) AddEventHandler(handler cache.ResourceEventHandler) error {
+	if rc.informer == nil {
+		return fmt.Errorf("rayservice informer is not initialized")
+	}
+	_, err := rc.informer.AddEventHandler(handler)
+	return err
+}
+
+// covers reports whether reads in the namespace can be served from the cache
+func (rc *RayServiceCache) covers(namespace string) bool {
+	return rc.HasSynced() && (rc.namespace == "" || rc.namespace == namespace)
+}
+
+// Get returns the rayservice from the cache, falling back to a live read on a miss
+func (rc *RayServiceCache) Get(ctx context.Context, namespace, name string) (*rayv1.RayService, error) {
+	if rc.covers(namespace) {
+		rayService, err := rc.lister.RayServices(namespace).Get(name)
+		if err == nil {
+			return rayService, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return rc.client.Clientset.RayV1().RayServices(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// List returns the rayservices in the namespace matching the label selector
+func (rc *RayServiceCache) List(ctx context.Context, namespace, labelSelector string) (*rayv1.RayServiceList, error) {
+	if !rc.covers(namespace) {
+		return rc.client.Clientset.RayV1().RayServices(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: labelSelector,
+		})
+	}
+
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	rayServices, err := rc.lister.RayServices(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	rayServicesList := &rayv1.RayServiceList{
+		TypeMeta: metav1.TypeMeta{Kind: "RayServiceList", APIVersion: rayv1.GroupVersion.String()},
+		Items:    make([]rayv1.RayService, 0, len(rayServices)),
+	}
+	for _, rayService := range rayServices {
+		rayServicesList.Items = append(rayServicesList.Items, *rayService.DeepCopy())
+	}
+	return rayServicesList, nil
+}
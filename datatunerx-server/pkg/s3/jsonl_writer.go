@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/DataTunerX/utility-server/logging"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// JSONLWriter buffers records in memory and flushes them asynchronously to S3,
+// one JSONL object per prefix and flush
+type JSONLWriter struct {
+	client        S3Client
+	bucketName    string
+	batchSize     int
+	flushInterval time.Duration
+	records       chan jsonlRecord
+
+	// mu guards closed, Close waits for the writes in progress before draining records
+	mu      sync.RWMutex
+	closed  bool
+	closing chan context.Context
+	done    chan struct{}
+}
+
+type jsonlRecord struct {
+	prefix string
+	data   []byte
+}
+
+// NewJSONLWriter creates a JSONLWriter and starts its flush loop.
+// Records are flushed once batchSize of them are pending or every flushInterval.
+func NewJSONLWriter(client S3Client, bucketName string, batchSize, bufferSize int, flushInterval time.Duration) *JSONLWriter {
+	w := &JSONLWriter{
+		client:        client,
+		bucketName:    bucketName,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		records:       make(chan jsonlRecord, bufferSize),
+		closing:       make(chan context.Context, 1),
+		done:          make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+// Write queues a record under the prefix without blocking, it returns false if the record was dropped
+func (w *JSONLWriter) Write(prefix string, record interface{}) bool {
+	data, err := json.Marshal(record)
+	if err != nil {
+		logging.ZLogger.Errorf("Failed to marshal JSONL record: %v", err)
+		return false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		logging.ZLogger.Warnf("JSONL writer closed, dropping record for %s", prefix)
+		return false
+	}
+	select {
+	case w.records <- jsonlRecord{prefix: prefix, data: data}:
+		return true
+	default:
+		logging.ZLogger.Warnf("JSONL writer buffer full, dropping record for %s", prefix)
+		return false
+	}
+}
+
+// Close flushes the queued records and stops the flush loop, waiting until they are flushed or ctx is done.
+// Records written after Close are dropped.
+func (w *JSONLWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		w.closing <- ctx
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *JSONLWriter) loop() {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string]*bytes.Buffer)
+	count := 0
+	add := func(record jsonlRecord) {
+		buf, ok := pending[record.prefix]
+		if !ok {
+			buf = &bytes.Buffer{}
+			pending[record.prefix] = buf
+		}
+		buf.Write(record.data)
+		buf.WriteByte('\n')
+		count++
+	}
+	for {
+		select {
+		case record := <-w.records:
+			add(record)
+			if count < w.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if count == 0 {
+				continue
+			}
+		case ctx := <-w.closing:
+			// Close 之后不会再有新记录入队，取完队列中剩余的记录后一并写入
+			for len(w.records) > 0 {
+				add(<-w.records)
+			}
+			w.flush(ctx, pending)
+			close(w.done)
+			return
+		}
+		w.flush(context.Background(), pending)
+		pending = make(map[string]*bytes.Buffer)
+		count = 0
+	}
+}
+
+func (w *JSONLWriter) flush(ctx context.Context, pending map[string]*bytes.Buffer) {
+	for prefix, buf := range pending {
+		now := time.Now().UTC()
+		objectName := fmt.Sprintf("%s/%s/%d-%s.jsonl", prefix, now.Format("2006/01/02"), now.UnixNano(), rand.String(6))
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		if err := w.client.PutBytes(ctx, w.bucketName, objectName, buf.Bytes(), "application/x-ndjson"); err != nil {
+			logging.ZLogger.Errorf("Failed to flush JSONL records to %s/%s: %v", w.bucketName, objectName, err)
+		}
+		cancel()
+	}
+}
@@ -1,6 +1,12 @@
 package s3
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
@@ -24,3 +30,32 @@ func NewS3Client(endpoint, accessKey, secretKey string, useSSL bool) (S3Client,
 		Client: minioClient,
 	}, nil
 }
+
+// ParseS3URL splits an s3://bucket/object URL, as returned by the upload API, into bucket and object name
+func ParseS3URL(rawURL string) (string, string, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	if parsedURL.Scheme != "s3" {
+		return "", "", fmt.Errorf("unsupported scheme %q, expected s3", parsedURL.Scheme)
+	}
+	objectName := strings.TrimPrefix(parsedURL.Path, "/")
+	if parsedURL.Host == "" || objectName == "" {
+		return "", "", fmt.Errorf("invalid s3 url %q, expected s3://bucket/object", rawURL)
+	}
+	return parsedURL.Host, objectName, nil
+}
+
+// PutBytes uploads data as a single object
+func (s S3Client) PutBytes(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+	_, err := s.Client.PutObject(ctx, bucketName, objectName, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+// ObjectURL returns the public URL of an object
+func (s S3Client) ObjectURL(bucketName, objectName string) string {
+	return s.Client.EndpointURL().String() + "/" + bucketName + "/" + objectName
+}
@@ -0,0 +1,113 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Keys of the usage ConfigMap
+const (
+	usageDataKey = "usage.json"
+	// QuotaDataKey holds the monthly token quota of the namespace, edited by administrators
+	QuotaDataKey = "monthlyTokenQuota"
+)
+
+// ConfigMapStore stores the usage of each namespace in a ConfigMap of that namespace
+type ConfigMapStore struct {
+	client kubernetes.Interface
+	name   string
+}
+
+// NewConfigMapStore creates a ConfigMapStore using ConfigMaps with the given name
+func NewConfigMapStore(client kubernetes.Interface, name string) *ConfigMapStore {
+	return &ConfigMapStore{client: client, name: name}
+}
+
+// Load reads the usage of the namespace, a missing ConfigMap is empty usage
+func (s *ConfigMapStore) Load(ctx context.Context, namespace string) (*Usage, error) {
+	configMap, err := s.client.CoreV1().ConfigMaps(namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &Usage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeUsage(configMap)
+}
+
+// Update applies mutate to the stored usage, creating the ConfigMap if needed and retrying on conflicts
+func (s *ConfigMapStore) Update(ctx context.Context, namespace string, mutate func(*Usage)) (*Usage, error) {
+	var saved *Usage
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMaps := s.client.CoreV1().ConfigMaps(namespace)
+		configMap, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      s.name,
+					Namespace: namespace,
+					Labels:    map[string]string{"app.kubernetes.io/managed-by": "datatunerx-server"},
+				},
+			}
+		} else if err != nil {
+			return err
+		}
+
+		usage, err := decodeUsage(configMap)
+		if err != nil {
+			return err
+		}
+		mutate(usage)
+		data, err := json.Marshal(usage)
+		if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[usageDataKey] = string(data)
+
+		if create {
+			_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// 其他副本已创建，按冲突重试
+				return apierrors.NewConflict(corev1.Resource("configmaps"), s.name, err)
+			}
+		} else {
+			_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+		saved = usage
+		return nil
+	})
+	return saved, err
+}
+
+func decodeUsage(configMap *corev1.ConfigMap) (*Usage, error) {
+	usage := &Usage{}
+	if data := configMap.Data[usageDataKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), usage); err != nil {
+			return nil, fmt.Errorf("invalid %s in configmap %s/%s: %v", usageDataKey, configMap.Namespace, configMap.Name, err)
+		}
+	}
+	if value, ok := configMap.Data[QuotaDataKey]; ok {
+		quota, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in configmap %s/%s: %v", QuotaDataKey, configMap.Namespace, configMap.Name, err)
+		}
+		usage.MonthlyTokenQuota = &quota
+	}
+	return usage, nil
+}
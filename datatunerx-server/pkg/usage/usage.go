@@ -0,0 +1,395 @@
+package usage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DataTunerX/utility-server/logging"
+)
+
+// Granularity of a usage bucket
+const (
+	Hour = "hour"
+	Day  = "day"
+)
+
+// OtherCallers is the caller of buckets whose callers were merged to keep the stored usage bounded
+const OtherCallers = "*"
+
+// Counters are the accumulated requests and tokens of a bucket
+type Counters struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"promptTokens"`
+	CompletionTokens int64 `json:"completionTokens"`
+	TotalTokens      int64 `json:"totalTokens"`
+}
+
+// Add adds other to c
+func (c *Counters) Add(other Counters) {
+	c.Requests += other.Requests
+	c.PromptTokens += other.PromptTokens
+	c.CompletionTokens += other.CompletionTokens
+	c.TotalTokens += other.TotalTokens
+}
+
+// Bucket holds the usage of one rayservice and caller over an hour or a day starting at Start (UTC)
+type Bucket struct {
+	Start   time.Time `json:"start"`
+	Service string    `json:"service"`
+	Caller  string    `json:"caller"`
+	Counters
+}
+
+// Usage is the usage of one namespace as persisted by a Store
+type Usage struct {
+	Hourly []Bucket `json:"hourly"`
+	Daily  []Bucket `json:"daily"`
+	// MonthlyTokenQuota overrides the default quota of the namespace when set, zero disables it
+	MonthlyTokenQuota *int64 `json:"-"`
+}
+
+// Store persists the usage of a namespace
+type Store interface {
+	Load(ctx context.Context, namespace string) (*Usage, error)
+	// Update applies mutate to the stored usage and saves it, returning the saved usage
+	Update(ctx context.Context, namespace string, mutate func(*Usage)) (*Usage, error)
+}
+
+type bucketKey struct {
+	start   int64
+	service string
+	caller  string
+}
+
+type buckets map[bucketKey]*Counters
+
+func (b buckets) add(start time.Time, service, caller string, counters Counters) {
+	key := bucketKey{start: start.Unix(), service: service, caller: caller}
+	existing, ok := b[key]
+	if !ok {
+		existing = &Counters{}
+		b[key] = existing
+	}
+	existing.Add(counters)
+}
+
+func (b buckets) addAll(list []Bucket) {
+	for _, bucket := range list {
+		b.add(bucket.Start, bucket.Service, bucket.Caller, bucket.Counters)
+	}
+}
+
+// list returns the buckets starting at or after since, sorted by start, service and caller
+func (b buckets) list(since time.Time) []Bucket {
+	list := make([]Bucket, 0, len(b))
+	for key, counters := range b {
+		if key.start < since.Unix() {
+			continue
+		}
+		list = append(list, Bucket{
+			Start:    time.Unix(key.start, 0).UTC(),
+			Service:  key.service,
+			Caller:   key.caller,
+			Counters: *counters,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Start.Equal(list[j].Start) {
+			return list[i].Start.Before(list[j].Start)
+		}
+		if list[i].Service != list[j].Service {
+			return list[i].Service < list[j].Service
+		}
+		return list[i].Caller < list[j].Caller
+	})
+	return list
+}
+
+// namespaceUsage is the last usage read from the store plus the usage recorded since then
+type namespaceUsage struct {
+	persisted *Usage
+	hourly    buckets
+	daily     buckets
+	// flushing is the usage being merged into the store, still counted until the flush returns
+	flushing *namespaceUsage
+	// monthTokens caches the tokens of persisted in the month starting at monthStart
+	monthOf     *Usage
+	monthStart  int64
+	monthTokens int64
+}
+
+// Tracker accumulates usage in memory and periodically merges it into the Store,
+// so several server replicas can share one Store
+type Tracker struct {
+	store           Store
+	hourlyRetention time.Duration
+	dailyRetention  time.Duration
+	maxBuckets      int
+
+	mu         sync.Mutex
+	namespaces map[string]*namespaceUsage
+}
+
+// NewTracker creates a Tracker and starts its flush loop.
+// Hourly and daily buckets older than their retention are dropped on flush, and at most maxBuckets
+// buckets are stored per namespace, see compact.
+func NewTracker(store Store, flushInterval, hourlyRetention, dailyRetention time.Duration, maxBuckets int) *Tracker {
+	t := &Tracker{
+		store:           store,
+		hourlyRetention: hourlyRetention,
+		dailyRetention:  dailyRetention,
+		maxBuckets:      maxBuckets,
+		namespaces:      make(map[string]*namespaceUsage),
+	}
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			t.Flush(context.Background())
+		}
+	}()
+	return t
+}
+
+// Record adds the usage of one request made at now
+func (t *Tracker) Record(namespace, service, caller string, counters Counters, now time.Time) {
+	now = now.UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ns, ok := t.namespaces[namespace]
+	if !ok {
+		ns = &namespaceUsage{}
+		t.namespaces[namespace] = ns
+	}
+	if ns.hourly == nil {
+		ns.hourly = make(buckets)
+		ns.daily = make(buckets)
+	}
+	ns.hourly.add(now.Truncate(time.Hour), service, caller, counters)
+	ns.daily.add(startOfDay(now), service, caller, counters)
+}
+
+// Flush merges the recorded usage into the store and refreshes the usage read from it
+func (t *Tracker) Flush(ctx context.Context) {
+	t.mu.Lock()
+	pending := t.namespaces
+	t.namespaces = make(map[string]*namespaceUsage, len(pending))
+	for namespace, ns := range pending {
+		current := &namespaceUsage{persisted: ns.persisted}
+		if ns.hourly != nil {
+			current.flushing = ns
+		}
+		t.namespaces[namespace] = current
+	}
+	t.mu.Unlock()
+
+	for namespace, ns := range pending {
+		now := time.Now().UTC()
+		var usage *Usage
+		var err error
+		if ns.hourly == nil {
+			usage, err = t.store.Load(ctx, namespace)
+		} else {
+			usage, err = t.store.Update(ctx, namespace, func(stored *Usage) {
+				hourly := make(buckets)
+				hourly.addAll(stored.Hourly)
+				hourly.addAll(ns.hourly.list(time.Time{}))
+				stored.Hourly = hourly.list(now.Add(-t.hourlyRetention).Truncate(time.Hour))
+				daily := make(buckets)
+				daily.addAll(stored.Daily)
+				daily.addAll(ns.daily.list(time.Time{}))
+				stored.Daily = daily.list(startOfDay(now.Add(-t.dailyRetention)))
+				stored.Hourly, stored.Daily = compact(stored.Hourly, stored.Daily, t.maxBuckets, StartOfMonth(now))
+			})
+		}
+
+		t.mu.Lock()
+		current := t.namespaces[namespace]
+		current.flushing = nil
+		if err != nil {
+			logging.ZLogger.Errorf("Failed to flush inference usage of namespace %s: %v", namespace, err)
+			// 保留未写入的用量，下次重试
+			if ns.hourly != nil {
+				if current.hourly == nil {
+					current.hourly = make(buckets)
+					current.daily = make(buckets)
+				}
+				current.hourly.addAll(ns.hourly.list(time.Time{}))
+				current.daily.addAll(ns.daily.list(time.Time{}))
+			}
+		} else {
+			current.persisted = usage
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Get returns the usage of the namespace including what has not been flushed yet
+func (t *Tracker) Get(ctx context.Context, namespace string) (*Usage, error) {
+	if err := t.load(ctx, namespace); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ns := t.namespaces[namespace]
+	hourly := make(buckets)
+	daily := make(buckets)
+	hourly.addAll(ns.persisted.Hourly)
+	daily.addAll(ns.persisted.Daily)
+	for _, recorded := range []*namespaceUsage{ns, ns.flushing} {
+		if recorded != nil && recorded.hourly != nil {
+			hourly.addAll(recorded.hourly.list(time.Time{}))
+			daily.addAll(recorded.daily.list(time.Time{}))
+		}
+	}
+	return &Usage{
+		Hourly:            hourly.list(time.Time{}),
+		Daily:             daily.list(time.Time{}),
+		MonthlyTokenQuota: ns.persisted.MonthlyTokenQuota,
+	}, nil
+}
+
+// load reads the namespace from the store unless it has been read before
+func (t *Tracker) load(ctx context.Context, namespace string) error {
+	t.mu.Lock()
+	ns, ok := t.namespaces[namespace]
+	loaded := ok && ns.persisted != nil
+	t.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	usage, err := t.store.Load(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ns, ok = t.namespaces[namespace]
+	if !ok {
+		ns = &namespaceUsage{}
+		t.namespaces[namespace] = ns
+	}
+	if ns.persisted == nil {
+		ns.persisted = usage
+	}
+	return nil
+}
+
+// MonthlyTokens returns the total tokens used by the namespace in the calendar month (UTC) of now,
+// and the quota stored for the namespace if any. It is called on every request: the stored usage is
+// summed once per flush, only the usage recorded since is summed on each call.
+func (t *Tracker) MonthlyTokens(ctx context.Context, namespace string, now time.Time) (int64, *int64, error) {
+	if err := t.load(ctx, namespace); err != nil {
+		return 0, nil, err
+	}
+	monthStart := StartOfMonth(now)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ns := t.namespaces[namespace]
+	if ns.monthOf != ns.persisted || ns.monthStart != monthStart.Unix() {
+		ns.monthOf = ns.persisted
+		ns.monthStart = monthStart.Unix()
+		ns.monthTokens = 0
+		for _, bucket := range ns.persisted.Daily {
+			if !bucket.Start.Before(monthStart) {
+				ns.monthTokens += bucket.TotalTokens
+			}
+		}
+	}
+	total := ns.monthTokens
+	for _, recorded := range []*namespaceUsage{ns, ns.flushing} {
+		if recorded == nil || recorded.daily == nil {
+			continue
+		}
+		for key, counters := range recorded.daily {
+			if key.start >= monthStart.Unix() {
+				total += counters.TotalTokens
+			}
+		}
+	}
+	return total, ns.persisted.MonthlyTokenQuota, nil
+}
+
+// compact keeps at most maxBuckets buckets, so that the usage of a namespace fits in its ConfigMap. In turn
+// it merges the callers of the hourly buckets, drops the oldest hourly buckets, merges the callers of the daily
+// buckets and drops the oldest daily buckets before monthStart. The daily totals of the current month, which
+// the quota is checked against, are always kept. Buckets are sorted by start.
+func compact(hourly, daily []Bucket, maxBuckets int, monthStart time.Time) ([]Bucket, []Bucket) {
+	if maxBuckets <= 0 || len(hourly)+len(daily) <= maxBuckets {
+		return hourly, daily
+	}
+	hourly = mergeCallers(hourly)
+	if over := len(hourly) + len(daily) - maxBuckets; over > 0 {
+		if over > len(hourly) {
+			over = len(hourly)
+		}
+		hourly = hourly[over:]
+	}
+	if len(hourly)+len(daily) <= maxBuckets {
+		return hourly, daily
+	}
+	daily = mergeCallers(daily)
+	for len(hourly)+len(daily) > maxBuckets && len(daily) > 0 && daily[0].Start.Before(monthStart) {
+		daily = daily[1:]
+	}
+	return hourly, daily
+}
+
+// mergeCallers sums the buckets of every caller per start and service under OtherCallers
+func mergeCallers(list []Bucket) []Bucket {
+	merged := make(buckets)
+	for _, bucket := range list {
+		merged.add(bucket.Start, bucket.Service, OtherCallers, bucket.Counters)
+	}
+	return merged.list(time.Time{})
+}
+
+// Filter selects and groups buckets in Aggregate
+type Filter struct {
+	From           time.Time
+	To             time.Time
+	Service        string
+	Caller         string
+	GroupByService bool
+	GroupByCaller  bool
+}
+
+// Aggregate sums the buckets in [From, To) matching the filter per bucket start, keeping the
+// service and caller apart only when grouping by them
+func Aggregate(source []Bucket, filter Filter) []Bucket {
+	aggregated := make(buckets)
+	for _, bucket := range source {
+		if bucket.Start.Before(filter.From) || !bucket.Start.Before(filter.To) {
+			continue
+		}
+		if (filter.Service != "" && bucket.Service != filter.Service) || (filter.Caller != "" && bucket.Caller != filter.Caller) {
+			continue
+		}
+		service, caller := "", ""
+		if filter.GroupByService {
+			service = bucket.Service
+		}
+		if filter.GroupByCaller {
+			caller = bucket.Caller
+		}
+		aggregated.add(bucket.Start, service, caller, bucket.Counters)
+	}
+	return aggregated.list(time.Time{})
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// StartOfMonth returns the first instant (UTC) of the month of t
+func StartOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,147 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DataTunerX/utility-server/logging"
+)
+
+func init() {
+	logging.NewZapLogger("error")
+}
+
+type memoryStore struct {
+	usage map[string]*Usage
+	err   error
+}
+
+func (s *memoryStore) Load(ctx context.Context, namespace string) (*Usage, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if usage, ok := s.usage[namespace]; ok {
+		copied := *usage
+		return &copied, nil
+	}
+	return &Usage{}, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, namespace string, mutate func(*Usage)) (*Usage, error) {
+	usage, err := s.Load(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	mutate(usage)
+	s.usage[namespace] = usage
+	copied := *usage
+	return &copied, nil
+}
+
+func newTestTracker(store Store) *Tracker {
+	return &Tracker{
+		store:           store,
+		hourlyRetention: 7 * 24 * time.Hour,
+		dailyRetention:  62 * 24 * time.Hour,
+		namespaces:      make(map[string]*namespaceUsage),
+	}
+}
+
+func TestTrackerRecordAndFlush(t *testing.T) {
+	store := &memoryStore{usage: map[string]*Usage{}}
+	tracker := newTestTracker(store)
+	now := time.Now().UTC()
+
+	tracker.Record("default", "llama", "alice", Counters{Requests: 1, PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}, now)
+	tracker.Record("default", "llama", "alice", Counters{Requests: 1, PromptTokens: 20, CompletionTokens: 5, TotalTokens: 25}, now)
+	tracker.Record("default", "llama", "bob", Counters{Requests: 1, TotalTokens: 7}, now)
+
+	used, _, err := tracker.MonthlyTokens(context.Background(), "default", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 47 {
+		t.Errorf("Expected 47 tokens before flush, got %d", used)
+	}
+
+	tracker.Flush(context.Background())
+	if got := len(store.usage["default"].Daily); got != 2 {
+		t.Fatalf("Expected 2 daily buckets in the store, got %d", got)
+	}
+
+	tracker.Record("default", "llama", "alice", Counters{Requests: 1, TotalTokens: 3}, now)
+	used, _, err = tracker.MonthlyTokens(context.Background(), "default", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 50 {
+		t.Errorf("Expected 50 tokens after flush, got %d", used)
+	}
+}
+
+func TestTrackerKeepsUsageWhenFlushFails(t *testing.T) {
+	store := &memoryStore{usage: map[string]*Usage{}}
+	tracker := newTestTracker(store)
+	now := time.Now().UTC()
+
+	tracker.Record("default", "llama", "alice", Counters{Requests: 1, TotalTokens: 10}, now)
+	store.err = errors.New("unavailable")
+	tracker.Flush(context.Background())
+	store.err = nil
+	tracker.Flush(context.Background())
+
+	if got := store.usage["default"].Daily; len(got) != 1 || got[0].TotalTokens != 10 {
+		t.Errorf("Expected the usage to be written on retry, got %+v", got)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	source := []Bucket{
+		{Start: day, Service: "a", Caller: "alice", Counters: Counters{Requests: 1, TotalTokens: 10}},
+		{Start: day, Service: "b", Caller: "alice", Counters: Counters{Requests: 2, TotalTokens: 20}},
+		{Start: day, Service: "b", Caller: "bob", Counters: Counters{Requests: 3, TotalTokens: 30}},
+		{Start: day.AddDate(0, 0, 1), Service: "a", Caller: "bob", Counters: Counters{Requests: 4, TotalTokens: 40}},
+	}
+
+	buckets := Aggregate(source, Filter{From: day, To: day.AddDate(0, 0, 1), GroupByCaller: true})
+	if len(buckets) != 2 || buckets[0].Caller != "alice" || buckets[0].TotalTokens != 30 || buckets[1].TotalTokens != 30 {
+		t.Errorf("Unexpected buckets grouped by caller: %+v", buckets)
+	}
+
+	buckets = Aggregate(source, Filter{From: day, To: day.AddDate(0, 0, 2), Service: "a"})
+	if len(buckets) != 2 || buckets[0].TotalTokens != 10 || buckets[1].TotalTokens != 40 {
+		t.Errorf("Unexpected buckets filtered by service: %+v", buckets)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	month := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	hourly := []Bucket{
+		{Start: month.Add(-time.Hour), Service: "a", Caller: "alice", Counters: Counters{TotalTokens: 1}},
+		{Start: month, Service: "a", Caller: "alice", Counters: Counters{TotalTokens: 2}},
+		{Start: month, Service: "a", Caller: "bob", Counters: Counters{TotalTokens: 3}},
+	}
+	daily := []Bucket{
+		{Start: month.AddDate(0, 0, -1), Service: "a", Caller: "alice", Counters: Counters{TotalTokens: 1}},
+		{Start: month, Service: "a", Caller: "alice", Counters: Counters{TotalTokens: 2}},
+		{Start: month, Service: "a", Caller: "bob", Counters: Counters{TotalTokens: 3}},
+	}
+
+	gotHourly, gotDaily := compact(hourly, daily, 6, month)
+	if len(gotHourly) != 3 || len(gotDaily) != 3 {
+		t.Errorf("Expected the buckets within the limit to be kept, got %+v %+v", gotHourly, gotDaily)
+	}
+
+	gotHourly, gotDaily = compact(hourly, daily, 4, month)
+	if len(gotHourly) != 1 || gotHourly[0].Caller != OtherCallers || gotHourly[0].TotalTokens != 5 || len(gotDaily) != 3 {
+		t.Errorf("Expected the hourly callers to be merged and the oldest hour dropped, got %+v %+v", gotHourly, gotDaily)
+	}
+
+	gotHourly, gotDaily = compact(hourly, daily, 1, month)
+	if len(gotHourly) != 0 || len(gotDaily) != 1 || gotDaily[0].Caller != OtherCallers || gotDaily[0].TotalTokens != 5 {
+		t.Errorf("Expected only the daily total of the current month to be kept, got %+v %+v", gotHourly, gotDaily)
+	}
+}
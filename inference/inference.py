@@ -1,7 +1,8 @@
 import re
 import os
 import time
-from transformers import LlamaForCausalLM, LlamaTokenizer, GenerationConfig, pipeline
+import torch
+from transformers import LlamaForCausalLM, LlamaTokenizer, GenerationConfig, StoppingCriteria, StoppingCriteriaList, pipeline
 from peft import PeftModel
 from ray import serve
 
@@ -169,17 +170,72 @@ class ChatCompletionResponse:
 origin_model_dir = os.getenv("BASE_MODEL_DIR")
 checkpoint_dir = os.getenv("CHECKPOINT_DIR")
 
+DEFAULT_SYSTEM_PROMPT = """You are a helpful, respectful and honest assistant. Always answer as helpfully as possible, while being safe.  Your answers should not include any harmful, unethical, racist, sexist, toxic, dangerous, or illegal content. Please ensure that your responses are socially unbiased and positive in nature.
+
+If a question does not make any sense, or is not factually coherent, explain why instead of answering something not correct. If you don't know the answer to a question, please don't share false information."""
+
 # Generate prompts from Llama2-13B template
-def generate_prompt(input):
-    return f"""
-<s>[INST] <<SYS>>
-You are a helpful, respectful and honest assistant. Always answer as helpfully as possible, while being safe.  Your answers should not include any harmful, unethical, racist, sexist, toxic, dangerous, or illegal content. Please ensure that your responses are socially unbiased and positive in nature.
+# messages 为完整的对话：可选的 system 消息，之后 user 与 assistant 交替，最后一条为 user
+def generate_prompt(messages):
+    system = DEFAULT_SYSTEM_PROMPT
+    if messages and messages[0].get("role") == "system":
+        system = messages[0].get("content", "")
+        messages = messages[1:]
+    turns = []
+    user = None
+    for message in messages:
+        if message.get("role") == "assistant":
+            if user is not None:
+                turns.append((user, message.get("content", "")))
+                user = None
+        else:
+            # 连续的 user 消息合并为一轮
+            content = message.get("content", "")
+            user = content if user is None else f"{user}\n{content}"
+    prompt = ""
+    for i, (user_content, assistant_content) in enumerate(turns):
+        if i == 0:
+            user_content = f"<<SYS>>\n{system}\n<</SYS>>\n\n{user_content}"
+        prompt += f"<s>[INST] {user_content} [/INST] {assistant_content} </s>"
+    user = user or ""
+    if not turns:
+        user = f"<<SYS>>\n{system}\n<</SYS>>\n\n{user}"
+    return f"\n{prompt}<s>[INST] {user} [/INST]\n"
+
+class StopOnSequences(StoppingCriteria):
+    """Stops generation once the newly generated text contains one of the stop sequences"""
+    def __init__(self, tokenizer, prompt_tokens, stop):
+        self.tokenizer = tokenizer
+        self.prompt_tokens = prompt_tokens
+        self.stop = stop
+
+    def __call__(self, input_ids, scores, **kwargs):
+        for sequence in input_ids:
+            text = self.tokenizer.decode(sequence[self.prompt_tokens:], skip_special_tokens=True)
+            if not any(stop in text for stop in self.stop):
+                return False
+        return True
 
-If a question does not make any sense, or is not factually coherent, explain why instead of answering something not correct. If you don't know the answer to a question, please don't share false information.
-<</SYS>>
 
-{input} [/INST]
-"""
+def repetition_penalty(frequency_penalty, presence_penalty):
+    # OpenAI 的惩罚项为 [-2, 2] 的加性值，transformers 的 repetition_penalty 为乘性值，1.0 表示不惩罚
+    penalty = max(-2.0, min(2.0, (frequency_penalty or 0) + (presence_penalty or 0)))
+    return 1.0 + penalty / 4
+
+
+def extract_output(generated_text, stop):
+    # 使用正则表达式提取大模型的输出，历史轮次中也有 [/INST]，只取最后一轮之后的内容
+    match = re.search(r'\[/INST\]\n((?:(?!\[/INST\]).)+)$', generated_text, re.DOTALL)
+    if not match:
+        return ""
+    output = match.group(1)
+    # 停止条件在生成的 token 中出现停止序列后才触发，截掉停止序列及之后的内容
+    for sequence in stop:
+        index = output.find(sequence)
+        if index >= 0:
+            output = output[:index]
+    return output.strip()
+
 
 class LlamaModel:
     def __init__(self):
@@ -187,15 +243,25 @@ class LlamaModel:
         self.model = PeftModel.from_pretrained(self.model, checkpoint_dir).cuda().eval()
         self.tokenizer = LlamaTokenizer.from_pretrained(origin_model_dir)
 
-    def generate(self, input, temperature: float = 0.1, top_p: float = 0.1, max_tokens: int = 10000, generation_kwargs={}):
-        prompt = generate_prompt(input)
+    def generate(self, messages, temperature: float = 0.1, top_p: float = 0.1, max_tokens: int = 10000, stop=None, seed=None, n: int = 1,
+                 frequency_penalty: float = 0, presence_penalty: float = 0, generation_kwargs={}):
+        prompt = generate_prompt(messages)
         inputs = self.tokenizer(prompt, return_tensors="pt")
         prompt_tokens = inputs["input_ids"].cuda().shape[1]
+        if isinstance(stop, str):
+            stop = [stop]
+        stop = stop or []
+        if seed is not None:
+            torch.manual_seed(seed)
+        # temperature 为 0 时按贪心解码
+        do_sample = temperature > 0
         config = GenerationConfig(
-            do_sample=True,
-            temperature=temperature,
+            do_sample=do_sample,
+            temperature=temperature if do_sample else None,
+            top_p=top_p if do_sample else None,
             max_new_tokens=max_tokens,
-            top_p=top_p,
+            num_return_sequences=n,
+            repetition_penalty=repetition_penalty(frequency_penalty, presence_penalty),
             **generation_kwargs,
         )
         pipe = pipeline(
@@ -208,18 +274,18 @@ class LlamaModel:
             framework="pt",
         )
         start_time = time.time()
-        generated_text = pipe(prompt)[0]["generated_text"]
+        stopping_criteria = StoppingCriteriaList([StopOnSequences(self.tokenizer, prompt_tokens, stop)]) if stop else None
+        generated = pipe(prompt, stopping_criteria=stopping_criteria)
         end_time = time.time()
         inference_time = end_time - start_time
-        # 使用正则表达式提取大模型的输出
-        match = re.search(r'\[/INST\]\n(.+)$', generated_text, re.DOTALL)
-        if match:
-            model_output = match.group(1).strip()
-            output = model_output
-        else:
-            output = ""
-        completion_tokens = self.tokenizer(output, return_tensors="pt")["input_ids"].cuda().shape[1]
-        choices = [{"index": 0, "message": {"role": "assistant", "content": output}, "logprobs": None, "finish_reason": "stop"}]
+        choices = []
+        completion_tokens = 0
+        for index, sequence in enumerate(generated):
+            output = extract_output(sequence["generated_text"], stop)
+            output_tokens = self.tokenizer(output, return_tensors="pt")["input_ids"].cuda().shape[1]
+            completion_tokens += output_tokens
+            finish_reason = "length" if output_tokens >= max_tokens else "stop"
+            choices.append({"index": index, "message": {"role": "assistant", "content": output}, "logprobs": None, "finish_reason": finish_reason})
         usage = {
             "completion_tokens": str(completion_tokens), 
             "prompt_tokens": str(prompt_tokens), 
@@ -238,9 +304,19 @@ class LlamaDeployment:
 
     async def __call__(self, request):
         body = await request.json()
-        input_data = body.get("messages")[0].get("content")
+        messages = body.get("messages")
         temperature = body.get("temperature", 0.1)
         top_p = body.get("top_p", 0.1)
-        return self.model.generate(input_data, temperature, top_p)
+        return self.model.generate(
+            messages,
+            temperature,
+            top_p,
+            max_tokens=body.get("max_tokens") or 10000,
+            stop=body.get("stop"),
+            seed=body.get("seed"),
+            n=body.get("n") or 1,
+            frequency_penalty=body.get("frequency_penalty") or 0,
+            presence_penalty=body.get("presence_penalty") or 0,
+        )
     
 deployment = LlamaDeployment.bind()
\ No newline at end of file